@@ -16,10 +16,15 @@ import (
 	s     "clojure/string"
 	insta "instaparse/core"
 	symbols "funcgo/symboltable"
+	"funcgo/comments"
+	"funcgo/diagnostic"
+	"funcgo/emitter"
 )
 import type (
-	java.util.List
-	java.io.IOException
+	clojure.lang.{Compiler, RT}
+	java.lang.reflect.Method
+	java.util.{Collections, List}
+	org.apache.commons.lang.StringUtils
 )
 
 kAsyncRules := set{
@@ -35,12 +40,317 @@ kAsyncRules := set{
 	SELECTSTMTINGO
 }
 
+// Parse rules whose generated Clojure forms carry :line, :column and
+// :file metadata pointing back at the Funcgo source.
+kLocatedRules := set{
+	FUNCTIONDECL,
+	VARDECL1,
+	FUNCTIONCALL,
+	WITHCONST,
+	WITHASSIGN,
+	TOPWITHCONST,
+	TOPWITHASSIGN,
+	STRUCTSPEC,
+	INTERFACESPEC,
+	IMPLEMENTS,
+	METHODDECL
+}
+
+// Parse rules whose generators check the code and take the source
+// position as their first argument, so that they can report where
+// any problem is.  A rule may be in kLocatedRules as well.
+kCheckedRules := set{
+	PACKAGECLAUSE,
+	IMPORTSPEC,
+	EXTERNIMPORTSPEC,
+	TYPEIMPORTSPEC,
+	FORCSTYLE,
+	ASSIGN,
+	SYMBOL,
+	TYPENAME,
+	METHODDECL,
+	STRUCTLIT,
+	KEYEDSTRUCTLIT,
+	IMPLEMENTS,
+	JAVAMETHODCALL,
+	STRUCTSPEC
+}
+
+kThis := symbol("this")
+
+// A splice holds the several forms generated by some rules, such as
+// the expressions of a block, which are inserted in its place into the
+// enclosing form.
+func isSplice(x) {
+	isVector(x) && get(meta(x), SPLICE, false)
+}
+
+// Return the forms that x stands for: those of a splice, or else x.
+func forms(x) {
+	if isSplice(x) {
+		x
+	} else {
+		[x]
+	}
+}
+
+// Return a splice of the given forms, in which the forms of any
+// splice among them are inserted.
+func splice(items...) {
+	withMeta(vec(mapcat(forms, items)), {SPLICE: true})
+}
+
+// Return a list of the given forms, in which the forms of any splice
+// among them are inserted.
+func listOf(items...) {
+	apply(list, mapcat(forms, items))
+}
+
+// Return a list whose head is the symbol with the given name.
+func listForm(head String, items...) {
+	apply(list, symbol(head), mapcat(forms, items))
+}
+
+func vecOf(items...) {
+	vec(mapcat(forms, items))
+}
+
+func mapOf(items...) {
+	apply(arrayMap, mapcat(forms, items))
+}
+
+// Return the symbol named by x, which may be a token of the parse
+// tree rather than something already generated.
+func sym(x) {
+	if isSymbol(x) {
+		x
+	} else {
+		symbol(str(x))
+	}
+}
+
+// Return the form with the given type hint.
+func hinted(form, typ) {
+	varyMeta(form, assoc, TAG, typ)
+}
+
+// Return whether the identifier is exported from its package, which it
+// is if it is capitalized.
+func IsPublic(identifier) {
+	name := str(identifier)
+	// not lowercode
+	!(/^\p{Ll}/  reFind  name) || name == "main" ||(/^bit-/  reFind  name)
+}
+
+// Split the path of a source file into the package prefix of its
+// directory, with dots for slashes, and its package name.
+func splitPath(path String) {
+	slash       := path->lastIndexOf(int('/'))
+	beforeSlash := subs(path, 0, slash + 1)
+	afterSlash  := subs(path, slash + 1)
+	[
+		s.replace(beforeSlash, '/', '.'),
+		s.replace(afterSlash, /\.gos?$/, "")
+	]
+}
+
+// Return the signatures of the methods declared or implemented by the
+// given (name [this params...] ...) forms, as a map of the name of each
+// method to the set of the numbers of parameters other than the
+// receiver that it is declared with.
+func signatures(methods) {
+	apply(mergeWith, into, {}, for method := lazy methods {
+		{str(first(method)): set{count(second(method)) - 1}}
+	})
+}
+
+// Return the signatures of the methods of the compiled interface with
+// the given class name, such as that of a protocol of a Funcgo package
+// already compiled, or nil if it is not on the classpath.  The class
+// is not initialized.
+func compiledSignatures(className String) {
+	clazz Class := try {
+		Class::forName(className, false, RT::baseLoader())
+	} catch Exception e {
+		nil
+	}
+	if clazz && clazz->isInterface() {
+		apply(mergeWith, into, {}, for m := lazy clazz->getMethods() {
+			method Method := m
+			{Compiler::demunge(method->getName()): set{count(method->getParameterTypes())}}
+		})
+	}
+}
+
+// Is the name of a method given perhaps a misspelling of the name of
+// the method wanted?
+func isMisspelling(given String, wanted String) {
+	given->equalsIgnoreCase(wanted) || StringUtils::getLevenshteinDistance(given, wanted) <= 2
+}
+
+// Return the symbol of the protocol of the methods with the given name
+// declared with receivers.
+func methodProtocol(method) {
+	symbol(str("__", method, "Protocol"))
+}
+
+// Return a splice of the defprotocol forms of the protocols of the
+// methods declared with receivers, which come straight after the ns
+// form so that the methods can be called anywhere in the file.  Each
+// has an arglist for every number of parameters that the method is
+// declared with for any type.
+func methodProtocols(symbolTable) {
+	splice(...(for [method, arities] := lazy sort(symbols.MethodArities(symbolTable)) {
+		arglists := for n := lazy sort(arities) {
+			vec(cons(kThis, for i := lazy range(1, n + 1) { symbol("x"  str  i) }))
+		}
+		listForm("defprotocol", withMeta(methodProtocol(method), {PRIVATE: true}),
+			listOf(symbol(method), ...arglists))
+	}))
+}
+
+// Return the numbers of parameters of the parts of the function in
+// the parse tree, other than any that are variadic.
+func functionArities(function) {
+	parts := if first(function) == FUNCTIONPARTS { rest(function) } else { [function] }
+	for part := lazy parts if first(part) == FUNCTIONPART0 || first(part) == FUNCTIONPARTN {
+		if first(part) == FUNCTIONPART0 { 0 } else { count(rest(second(part))) }
+	}
+}
+
+// Add the methods declared with receivers anywhere in the parse tree
+// to the symbol table before any code is generated, so that calls of
+// them can be told apart from Java interop wherever they are.
+func declareMethods(symbolTable, parsed) {
+	for node := range filter(func{isVector($1) && first($1) == METHODDECL}, treeSeq(isVector, rest, parsed)) {
+		[_, receiver, method, function] := node
+		typ := "."  s.join  rest(last(receiver))
+		symbols.MethodDeclared(symbolTable, typ, method, functionArities(function))
+	}
+}
+
+// Return the protocols that the type implements itself, by being
+// extended to them or by having methods declared with it as receiver.
+func ownProtocols(symbolTable, typ) {
+	into(symbols.Implemented(symbolTable, typ), map(methodProtocol, symbols.Methods(symbolTable, typ)))
+}
+
+// Return the protocols that the type implements, itself or by
+// embedding a struct that does.
+func protocolsOf(symbolTable, typ) {
+	into(ownProtocols(symbolTable, typ), mapcat(func{protocolsOf(symbolTable, $1)}, symbols.Embedded(symbolTable, typ)))
+}
+
+// Does the type have the method, declared with it as receiver or
+// promoted from a struct that it embeds?
+func hasMethod(symbolTable, typ, method) {
+	(symbols.Methods(symbolTable, typ)  isContains  method) ||
+		some(func{hasMethod(symbolTable, $1, method)}, symbols.Embedded(symbolTable, typ))
+}
+
+// Return the names of the types that the generated form is known to
+// have: those that a variable of its name is declared with, the struct
+// of a struct literal, or its type hint.
+func staticTypes(symbolTable, form) {
+	head String := if isSeq(form) && isSymbol(first(form)) { name(first(form)) } else { "" }
+	switch {
+	case isSymbol(form):
+		symbols.VariableTypes(symbolTable, str(form))
+	case head->startsWith("map->"):
+		set{subs(head, 5)}
+	case count(head) > 1 && head->endsWith("."):
+		set{subs(head, 0, count(head) - 1)}
+	case get(meta(form), TAG):
+		set{str(meta(form)(TAG))}
+	default:
+		set{}
+	}
+}
+
+// Return how deeply structs are embedded in the struct, which is 1 if
+// it embeds none.
+func embeddingDepth(symbolTable, typ) {
+	1 + reduce(max, 0, map(func{embeddingDepth(symbolTable, $1)}, symbols.Embedded(symbolTable, typ)))
+}
+
+// Return the promotions of the methods of embedded structs, which
+// promote them as in Go by extending each struct to the protocols that
+// the structs it embeds implement and it does not.  A promoted method
+// calls that of the first embedded struct that implements its
+// protocol, with a record of that struct made from the fields of the
+// outer one, which include all of its own.  Each promotion is a pair of
+// what it needs to come after, as given by provided, and its form.  The
+// structs that embed fewer levels come first.  ClojureScript protocols
+// do not expose their implementations, so there are none in Goscript.
+func promotions(symbolTable, isGoscript) {
+	k       := symbol("k")
+	f       := symbol("f")
+	args    := symbol("args")
+	structs := if isGoscript { [] } else { sort(symbols.Structs(symbolTable)) }
+	mapcat(func(typ) {
+		own      := ownProtocols(symbolTable, typ)
+		promoted := reduce(func(acc, embedded) {
+			into(acc, for p := lazy protocolsOf(symbolTable, embedded) if !(acc  isContains  p) && !(own  isContains  p) {
+				[p, embedded]
+			})
+		}, {}, symbols.Embedded(symbolTable, typ))
+		for [p, embedded] := lazy sortBy(func{str(first($1))}, promoted) {
+			[
+				set{[typ], [embedded, str(p)]},
+				listForm("extend", symbol(typ), p, listForm("into", {}, listForm("for",
+					[[k, f], listForm("get-in", p, [IMPLS, symbol(embedded)])],
+					[k, listForm("fn", [kThis, symbol("&"), args],
+						listForm("apply", f, listForm("map->"  str  embedded, kThis), args))]
+				)))
+			]
+		}
+	}, sortBy(func{embeddingDepth(symbolTable, $1)}, structs))
+}
+
+// Return what the top-level form provides for promotions: the name of
+// the struct that a defrecord defines, or the type and the protocol
+// that an extend-type or extend extends it to.
+func provided(form) {
+	if isSeq(form) && isSymbol(second(form)) {
+		switch str(first(form)) {
+		case "defrecord":             [str(second(form))]
+		case "extend-type", "extend": [str(second(form)), str(nth(form, 2))]
+		default:                      nil
+		}
+	}
+}
+
+// Return a splice of the top-level forms with each promotion inserted
+// straight after the forms that it needs, so that the promoted methods
+// can be called from there on.  Any promotion that needs something not
+// provided at the top level goes at the end.
+func withPromotions(topForms, promoted) {
+	loop(remaining = topForms, pending = promoted, done = set{}, acc = []) {
+		ready := filter(func{ isEvery(done, first($1)) }, pending)
+		switch {
+		case notEmpty(ready):
+			recur(
+				remaining,
+				remove(func{ isEvery(done, first($1)) }, pending),
+				into(done, map(func{provided(second($1))}, ready)),
+				into(acc, map(second, ready))
+			)
+		case isEmpty(remaining):
+			apply(splice, into(acc, map(second, pending)))
+		default:
+			recur(rest(remaining), pending, done  conj  provided(first(remaining)), acc  conj  first(remaining))
+		}
+	}
+}
+
 // Returns a map of parser targets to functions that generate the
-// corresponding Clojure code.
-func codeGenerator(symbolTable, isGoscript) {
+// corresponding Clojure forms.  The helper functions that close over
+// the arguments are local, rather than nested func declarations, which
+// would be global and so shared by files compiled concurrently.
+func codeGenerator(symbolTable, isGoscript, path, isLocated) {
 
 	// Convert camelcase to clojure-dasj-seprateted, e.g. fooBar to foo-bar
-	func camelcaseToDashed(idf string) {
+	camelcaseToDashed := func(idf string) {
 		idfTweaked := if idf->length() > 1 {
 			s.replace(idf, /^_/, "-")
 		} else {
@@ -51,11 +361,11 @@ func codeGenerator(symbolTable, isGoscript) {
 		)
 	}
 
-	func noDot(s String) {
+	noDot := func(s String) {
 		!(/\./  reFind  s)
 	}
 
-	func isJavaClass(clazz) {
+	isJavaClass := func(clazz) {
 		try{
 			Class::forName(clazz)
 			true
@@ -64,152 +374,263 @@ func codeGenerator(symbolTable, isGoscript) {
 		}
 	}
 
-	func hasType(typ String) {
-		(symbolTable  symbols.HasType  typ)
-		|| isGoscript && typ->startsWith("js.")
-		|| !isGoscript && noDot(typ) && isJavaClass("java.lang."  str  typ)
+	hasType := func(typ String) {
+		(symbolTable  symbols.HasType  typ) ||
+			isGoscript && typ->startsWith("js.") ||
+			!isGoscript && noDot(typ) && isJavaClass("java.lang."  str  typ)
+	}
+
+	// Record an error found at pos, to be reported once the whole file
+	// has been generated.
+	addError := func(code, pos, message, hints...) {
+		symbols.AddDiagnostic(symbolTable, diagnostic.Error(code, path, pos, message, ...hints))
 	}
 
-	func listStr(item...) {
-		str("(", s.join(" ", item), ")")
+	// Add metadata to the form giving its position in the Funcgo
+	// source, if known.
+	located := func(pos, form) {
+		if isLocated && pos {
+			varyMeta(form, merge, selectKeys(pos, [LINE, COLUMN]) += {FILE: path})
+		} else {
+			form
+		}
 	}
 
-	func blankJoin (xs...){
-		" "  s.join  xs
+	// Return the call of the method with the given name on the
+	// receiver expression.  It is a call of a method declared with a
+	// receiver if the receiver is known to be of a type that has it,
+	// and otherwise Java interop, which is an error if there is a
+	// method of that name declared with a receiver, since that may be
+	// what was meant.
+	methodCall := func(pos, expression, identifier, args) {
+		method := str(identifier)
+		types  := staticTypes(symbolTable, expression)
+		if some(func{hasMethod(symbolTable, $1, method)}, types) {
+			listOf(sym(identifier), expression, ...args)
+		} else {
+			if isEmpty(types) && symbols.HasMethod(symbolTable, method) {
+				addError(
+					"E0112", pos,
+					format(`cannot tell whether ->%s calls the method declared with a receiver or a Java method`, method),
+					format(`declare the type of the receiver, as in x T, or call the method as a function: %s(x)`, method)
+				)
+			}
+			listForm(".", expression, listOf(sym(identifier), ...args))
+		}
 	}
 
-	func vecStr(item...) {
-		str("[", s.join(" ", item), "]")
+	// Wrap the generators of kLocatedRules so that they take the
+	// position inserted by withPositions as their first argument,
+	// passing it on to those that are also in kCheckedRules.
+	locateAll := func(generators) {
+		into(generators, for rule := lazy kLocatedRules {
+			generate  := generators(rule)
+			isChecked := kCheckedRules  isContains  rule
+			[rule, func(pos, args...) {
+				located(pos, if isChecked { generate(pos, ...args) } else { generate(...args) })
+			}]
+		})
 	}
 
-	func infix(expression) {
-		expression
-	} (left, operator, right) {
-		listStr(operator, left, right)
+	// Return the signatures of the methods of the interface, if it
+	// was declared before in the file or is a compiled one on the
+	// classpath.
+	interfaceSignatures := func(typ) {
+		if declared := symbols.Signatures(symbolTable, typ); declared {
+			declared
+		} else {
+			if className := symbols.ClassName(symbolTable, typ); className && !isGoscript {
+				compiledSignatures(className)
+			}
+		}
 	}
 
-	// Capitalized
-	func isPublic(identifier) {
-		// not lowercode
-		!(/^\p{Ll}/  reFind  identifier) || identifier == "main" ||(/^bit-/  reFind  identifier)
+	// Report the methods of the interface that an implements of it
+	// leaves out, misspells or declares with the wrong number of
+	// parameters, if the methods of the interface are known.
+	checkImplements := func(pos, protocol, concrete, methodimpls) {
+		if required := interfaceSignatures(str(protocol)); required {
+			given      := signatures(methodimpls)
+			missing    := sort(remove(given, keys(required)))
+			unknown    := sort(remove(required, keys(given)))
+			misspelled := into({}, for method := lazy unknown {
+				[method, first(filter(func{isMisspelling(method, $1)}, missing))]
+			})
+			for method := range remove(set(vals(misspelled)), missing) {
+				addError(
+					"E0111", pos,
+					format(`%s does not implement %s: missing method %s`, concrete, protocol, method)
+				)
+			}
+			for method := range unknown {
+				hints := if misspelled(method) { [format(`did you mean %s?`, misspelled(method))] } else { [] }
+				addError(
+					"E0111", pos,
+					format(`%s does not implement %s: %s is not a method of %s`, concrete, protocol, method, protocol),
+					...hints
+				)
+			}
+			for [method, arities] := range sort(given) {
+				// Each arity of a method is implemented separately, so
+				// every one of them must be declared by the interface.
+				for arity := range sort(remove(get(required, method, arities), arities)) {
+					addError(
+						"E0111", pos,
+						format(
+							`%s does not implement %s: method %s has %d parameters, but %s declares it with %s`,
+							concrete, protocol, method, arity, protocol,
+							" or "  s.join  sort(required(method))
+						)
+					)
+				}
+			}
+		}
 	}
 
-	// Return a function that always returns the given constant string.
-	func constantFunc(s) {
-		func{s}
+	infix := func(expression) {
+		expression
+	} (left, operator, right) {
+		listOf(operator, left, right)
 	}
 
-	func splitPath(path String) {
-		slash       := path->lastIndexOf(int('/'))
-		beforeSlash := subs(path, 0, slash + 1)
-		afterSlash  := subs(path, slash + 1)
-		[
-			s.replace(beforeSlash, '/', '.'),
-			s.replace(afterSlash, /\.gos?$/, "")
-		]
+	// Return a function that always returns the symbol with the given
+	// name.
+	symbolFunc := func(name) {
+		constantly(symbol(name))
 	}
 
-	func declBlockFunc(typ) {
+	declBlockFunc := func(typ) {
 		func(xs...){
 			consts      := butlast(xs)
 			expressions := last(xs)
-			str("(", typ, " [",
-				" "  s.join  consts,
-				"] ",
-				expressions,
-				")")
+			listForm(typ, vecOf(...consts), expressions)
 		}
 	}
 
-	func stripQuotes(literal string) string{
+	stripQuotes := func(literal string) string{
 		literal->substring(1, literal->length() - 1)
 	}
 
-	func _importSpec(identifier, dotted) {
+	_importSpec := func(pos, identifier, dotted, imported) {
 		// As side effect, add to symbol table for future error checking
-		symbolTable  symbols.PackageImported  identifier
-		vecStr(dotted, ":as", identifier)
+		symbols.PackageImported(symbolTable, str(identifier), pos, imported)
+		vecOf(symbol(dotted), AS, sym(identifier))
 	}
 
-	func importSpec(imported) {
-		dotted := camelcaseToDashed(s.replace(stripQuotes(imported), '/', '.'))
-		_importSpec(last(dotted  s.split  /\./), dotted)
-	} (identifier, imported) {
-		dotted := camelcaseToDashed(s.replace(stripQuotes(imported), '/', '.'))
-		if identifier == "_" {
+	importSpec := func(pos, imported) {
+		dotted := camelcaseToDashed(s.replace(str(imported), '/', '.'))
+		_importSpec(pos, last(dotted  s.split  /\./), dotted, str(imported))
+	} (pos, identifier, imported) {
+		dotted := camelcaseToDashed(s.replace(str(imported), '/', '.'))
+		if str(identifier) == "_" {
 			// package imported for sideeffect only
-			vecStr(dotted)
+			vecOf(symbol(dotted))
 		} else {
 			// normal import
-			_importSpec(identifier, dotted)
+			_importSpec(pos, identifier, dotted, str(imported))
 		}
 	}
 
-	func externImportSpec(identifier) {
-		symbolTable  symbols.PackageImported  identifier
-		""
+	externImportSpec := func(pos, identifier) {
+		symbols.PackageImported(symbolTable, str(identifier), pos, str(identifier))
+		splice()
 	}
 
-	func vardecl(identifier, expression) {
-		if isPublic(identifier) {
-			listStr("def", identifier, expression)
-		} else {
-			listStr("def", "^:private", identifier, expression)
-		}
-	} (identifier, typ, expression) {
-		if isPublic(identifier) {
-			listStr("def", "^"  str  typ, identifier, expression)
-		} else {
-			listStr("def",
-				"^:private",
-				"^"  str  typ,
-				identifier,
-				expression
-			)
+	// Suggest imports for a type that is not in the type imports.
+	typeImportHints := func(typ String) {
+		if !isGoscript && noDot(typ) {
+			for pkg := lazy ["java.util", "java.io", "java.net"] if isJavaClass(str(pkg, ".", typ)) {
+				str("import type ", pkg, ".", typ)
+			}
 		}
 	}
 
-	func def_(identifier, expression) {
-		if isPublic(identifier) {
-			listStr("def", identifier, expression)
+	// Return the symbol being defined, marked private unless it is
+	// public.
+	defined := func(identifier) {
+		if IsPublic(identifier) {
+			identifier
 		} else {
-			listStr("def", "^:private", identifier, expression)
+			varyMeta(identifier, assoc, PRIVATE, true)
 		}
 	}
 
-	func sendClause(channel, val, expr) {
-		vecStr(channel  vecStr  val)  blankJoin   expr
+	vardecl := func(identifier, expression) {
+		listForm("def", defined(identifier), expression)
+	} (identifier, typ, expression) {
+		listForm("def", hinted(defined(identifier), typ), expression)
+	}
+
+	sendClause := func(channel, val, expr) {
+		splice(vecOf(vecOf(channel, val)), expr)
 	}
 
-	func doStr(expressions) {
-		"do"  listStr  expressions
+	doForm := func(expressions) {
+		listForm("do", expressions)
 	}
 
-	// Mapping from parse tree to generators of CLJ code.
-	{
-		SOURCEFILE:  blankJoin,
-		NONPKGFILE:  identity,
-		IMPORTDECLS: blankJoin,
+	// Return the test of whether x has the type, where a nil type is
+	// that of nil.
+	typeTest := func(x, typ) {
+		if isNil(typ) {
+			listForm("nil?", x)
+		} else {
+			listForm("instance?", typ, x)
+		}
+	}
+
+	// Return the clauses of a cond testing the type of x, from the
+	// clauses of a type switch, each a pair of its types, or nil for
+	// the default, and its expressions.  If the switch binds x, each
+	// clause with a single type other than nil binds it again with
+	// that type hint, so that Java calls on it need no reflection.
+	typeCases := func(x, isBound, clauses) {
+		for [types, expr] := lazy clauses {
+			typ := first(types)
+			splice(
+				switch {
+				case isNil(types):       ELSE
+				case count(types) == 1:  typeTest(x, typ)
+				default:                 listForm("or", ...map(func{typeTest(x, $1)}, types))
+				},
+				if isBound && count(types) == 1 && typ {
+					listForm("let", [hinted(x, typ), x], expr)
+				} else {
+					expr
+				}
+			)
+		}
+	}
+
+	// Mapping from parse tree to generators of Clojure forms.
+	locateAll({
+		SOURCEFILE:  func(packageclause, expressions) {
+			withPromotions(splice(packageclause, expressions), promotions(symbolTable, isGoscript))
+		},
+		NONPKGFILE:  func(expressions) {
+			withPromotions(splice(methodProtocols(symbolTable), expressions), promotions(symbolTable, isGoscript))
+		},
+		IMPORTDECLS: splice,
 		IMPORTSPEC: importSpec,
 		EXTERNIMPORTSPEC: externImportSpec,
 		EXCLUDE: func(symbols...) {
-			listStr(":refer-clojure", ":exclude", vecStr(...symbols))
+			listOf(REFER_CLOJURE, EXCLUDE, vecOf(...symbols))
 		},
 		TYPEIMPORTDECL: func() {
-			""
+			splice()
 		} (importSpecs...) {
-			listStr(":import", ...importSpecs)
+			listOf(IMPORT, ...importSpecs)
 		},
-		TYPEIMPORTSPEC: func(typepackage, typeclasses...) {
+		TYPEIMPORTSPEC: func(pos, typepackage, typeclasses...) {
 			for typeclass := range typeclasses {
-				symbolTable  symbols.TypeImported  typeclass
+				symbols.TypeImported(symbolTable, str(typeclass), pos, str(typepackage, ".", typeclass))
 			}
-			listStr(typepackage, ...typeclasses)
+			listOf(typepackage, ...map(sym, typeclasses))
 		},
 		TYPEPACKAGEIMPORTSPEC: func{
-			"."  s.join  $*
+			symbol("."  s.join  $*)
 		},
-		TYPECLASSESIMPORTSPEC: blankJoin,
+		TYPECLASSESIMPORTSPEC: splice,
 		PRECEDENCE00: infix,
 		PRECEDENCE0: infix,
 		PRECEDENCE1: infix,
@@ -218,458 +639,583 @@ func codeGenerator(symbolTable, isGoscript) {
 		PRECEDENCE4: infix,
 		PRECEDENCE5: infix,
 		IFELSEEXPR: func(condition, exprs) {
-			listStr("when", condition, exprs)
+			listForm("when", condition, exprs)
 		} (condition, block1, block2) {
-			listStr("if", condition, block1, block2)
+			listForm("if", condition, block1, block2)
 		},
 		LETIFELSEEXPR: func(lhs, rhs, condition, exprs) {
-			str("(let [", lhs, " ", rhs, "] ",
-				listStr("when", condition, exprs),
-				")"
-			)
+			listForm("let", vecOf(lhs, rhs), listForm("when", condition, exprs))
 		} (lhs, rhs, condition, block1, block2) {
-			str("(let [", lhs, " ", rhs, "] ",
-				listStr("if", condition, block1, block2),
-			")"
-			)
+			listForm("let", vecOf(lhs, rhs), listForm("if", condition, block1, block2))
 		},
 		ASSOC: func(symbol, items...) {
-			listStr("assoc", symbol, ...items)
+			listForm("assoc", symbol, ...items)
 		},
 		DISSOC: func(symbol, items...) {
-			listStr("dissoc", symbol, ...items)
+			listForm("dissoc", symbol, ...items)
 		},
 		ASSOCIN: func(symbol, path, value) {
-			listStr("assoc-in", symbol, path, value)
+			listForm("assoc-in", symbol, path, value)
 		},
-		ASSOCITEM: blankJoin,
-		ASSOCINPATH: vecStr,
+		ASSOCITEM: splice,
+		ASSOCINPATH: vecOf,
 		BOOLSWITCH: func(clauses...) {
-			listStr("cond", ...clauses)
+			listForm("cond", ...clauses)
 		},
-		BOOLCASECLAUSE: blankJoin,
+		BOOLCASECLAUSE: splice,
 		BOOLSWITCHCASE: func(){
-			":else"
+			ELSE
 		} (cond) {
 			cond
 		},
 		SELECTSTMT: func(clauses...){
-			listStr("alt!!", ...clauses)
+			listForm("alt!!", ...clauses)
 		},
 		SENDCLAUSE: func(channel, value) {
-			sendClause(channel, value, "nil")
+			sendClause(channel, value, nil)
 		} (channel, value, expressions) {
-			sendClause(channel, value, doStr(expressions))
+			sendClause(channel, value, doForm(expressions))
 		},
 		RECVCLAUSE: func(channel) {
-			channel  blankJoin  "nil"
+			splice(channel, nil)
 		} (channel, expressions) {
-			channel  blankJoin  doStr(expressions)
+			splice(channel, doForm(expressions))
 		},
 		RECVVALCLAUSE: func(identifier, channel, expressions) {
-			channel  blankJoin  (vecStr(identifier)  listStr   expressions)
+			splice(channel, listOf(vecOf(identifier), expressions))
 		},
 		DEFAULTCLAUSE: func() {
-			":default"
+			DEFAULT
 		} (expessions) {
-			":default"  blankJoin  doStr(expessions)
+			splice(DEFAULT, doForm(expessions))
 		},
 		SELECTSTMTINGO: func(clauses...){
-			listStr("alt!", ...clauses)
+			listForm("alt!", ...clauses)
 		},
 		SENDCLAUSEINGO: func(channel, value) {
-			sendClause(channel, value, "nil")
+			sendClause(channel, value, nil)
 		} (channel, value, expressions) {
-			sendClause(channel, value, doStr(expressions))
+			sendClause(channel, value, doForm(expressions))
 		},
 		RECVCLAUSEINGO: func(channel) {
-			channel  blankJoin  "nil"
+			splice(channel, nil)
 		} (channel, expressions) {
-			channel  blankJoin  doStr(expressions)
+			splice(channel, doForm(expressions))
 		},
 		RECVVALCLAUSEINGO: func(identifier, channel, expressions) {
-			channel  blankJoin  (vecStr(identifier)  listStr   expressions)
-		},
-		TYPESWITCH: func(x, args...) {
-			loop(acc="(cond", remaining=args) {
-				func typeCase() {
-					typ  := first(remaining)
-					expr := second(remaining)
-					str(acc, " ", listStr("instance?", typ, x), " ", expr)
-				}
-				switch count(remaining) {
-				case 1: {
-					[expr] := remaining
-					str(acc, " :else ", expr, ")")
-				}
-				case 2:
-					typeCase()  str  ")"
-				default:
-					recur(typeCase(), 2  drop  remaining)
-				}
-			}
+			splice(channel, listOf(vecOf(identifier), expressions))
+		},
+		TYPESWITCH: func(x, clauses...) {
+			listForm("cond", ...typeCases(x, false, clauses))
+		},
+		LETTYPESWITCH: func(identifier, expr, clauses...) {
+			listForm("let", vecOf(identifier, expr), listForm("cond", ...typeCases(identifier, true, clauses)))
+		},
+		TYPECASECLAUSE: func(args...) {
+			[vec(butlast(args)), last(args)]
+		},
+		NILCASE: constantly(nil),
+		TYPEDEFAULTCLAUSE: func(expressions) {
+			[nil, expressions]
 		},
 		CONSTSWITCH: func(expr, clauses...) {
-			listStr("case", expr, ...clauses)
+			listForm("case", expr, ...clauses)
 		},
 		LETCONSTSWITCH: func(lhs, rhs, expr, clauses...) {
-			str("(let [", lhs, " ", rhs, "] ",
-				listStr("case", expr, ...clauses),
-				")"
-			)
+			listForm("let", vecOf(lhs, rhs), listForm("case", expr, ...clauses))
 		},
-		CONSTCASECLAUSE: blankJoin,
+		CONSTCASECLAUSE: splice,
 		CONSTANTLIST: func(c) {
 			c
 		}(c0, c...){
-			listStr(c0, ...c)
+			listOf(c0, ...c)
 		},
 		CONSTSWITCHCASE: func(){
-			""
+			splice()
 		} (cond) {
 			cond
 		},
 		FORRANGE: func(identifier, seq, expressions) {
-			str("(doseq [", identifier, " ", seq, "] ", expressions, ")")
+			listForm("doseq", vecOf(identifier, seq), expressions)
 		},
 		FORLAZY: func(identifier, seq, expressions) {
-			str("(for [", identifier, " ", seq, "] ", expressions, ")")
+			listForm("for", vecOf(identifier, seq), expressions)
 		} (identifier, seq, condition, expressions) {
-			str("(for [", identifier, " ", seq, " :when ", condition, "] ", expressions, ")")
+			listForm("for", vecOf(identifier, seq, WHEN, condition), expressions)
 		},
-		FORTIMES: func(identifier, count, expressions) {
-			str("(dotimes [", identifier, " ", count, "] ", expressions, ")")
+		FORTIMES: func(identifier, n, expressions) {
+			listForm("dotimes", vecOf(identifier, n), expressions)
 		},
-		FORCSTYLE: func(ident, identAgain, count, identYetAgain, expressions) {
+		FORCSTYLE: func(pos, ident, identAgain, n, identYetAgain, expressions) {
 			if ident != identAgain || ident != identYetAgain {
-				throw(new IOException(
-					`cannot mix different identifiers in c-style for loop`
-				))
+				addError(
+					"E0106", pos,
+					`cannot mix different identifiers in c-style for loop`,
+					format(`use %s in all three clauses`, ident)
+				)
 			}
-			str("(dotimes [", ident, " ", count, "] ", expressions, ")")
+			listForm("dotimes", vecOf(ident, n), expressions)
 		},
 		TRYEXPR: func(expressions, catches) {
-			listStr("try", expressions, catches)
+			listForm("try", expressions, catches)
 		} (expressions, catches, finally) {
-			listStr("try", expressions, catches, finally)
+			listForm("try", expressions, catches, finally)
 		},
-		CATCHES: blankJoin,
+		CATCHES: splice,
 		CATCH: func(typ, exception, expressions) {
-			listStr("catch", typ, exception, expressions)
+			listForm("catch", typ, exception, expressions)
 		},
-		FINALLY: func{listStr("finally", $1)},
-		NEW:	 func{str($1, ".")},
+		FINALLY: func{listForm("finally", $1)},
+		NEW:	 func{symbol(str($1, "."))},
 		SHORTVARDECL:	func(identifier, expression) {
-			def_(identifier, expression)
+			vardecl(identifier, expression)
 		} (ident1, ident2, expr1, expr2) {
-			def_(ident1, expr1)  blankJoin  def_(ident2, expr2)
+			splice(vardecl(ident1, expr1), vardecl(ident2, expr2))
 		} (ident1, ident2, ident3, expr1, expr2, expr3) {
-			blankJoin(
-				def_(ident1, expr1),
-				def_(ident2, expr2),
-				def_(ident3, expr3)
+			splice(
+				vardecl(ident1, expr1),
+				vardecl(ident2, expr2),
+				vardecl(ident3, expr3)
 			)
 		},
 		PRIMARRAYVARDECL: func(identifier, number, primtype) {
-			elements := blankJoin(...(for _ := times readString(number) {"0"}))
-			listStr("def", identifier, listStr("vector-of", ":"  str  primtype, elements))
+			elements := repeat(number, 0)
+			listForm("def", identifier, listForm("vector-of", keyword(str(primtype)), ...elements))
 		},
 		ARRAYVARDECL: func(identifier, number, typ) {
-			elements := blankJoin(...(for _ := times readString(number) {"nil"}))
-			listStr("def", identifier, listStr("vector", elements))
+			elements := repeat(number, nil)
+			listForm("def", identifier, listForm("vector", ...elements))
 		},
 		VARDECL1: vardecl,
 		VARDECL2: func(identifier1, identifier2, expression1, expression2) {
-			blankJoin(
+			splice(
 				vardecl(identifier1, expression1),
 				vardecl(identifier2, expression2)
 			)
 		} (identifier1, identifier2, typ, expression1, expression2) {
-			blankJoin(
+			splice(
 				vardecl(identifier1, typ, expression1),
 				vardecl(identifier2, typ, expression2)
 			)
 		},
-		PREFIXEDROUTINE: listStr,
-		PREFIXEDBLOCK: listStr,
-		PREFIX: identity,
-		ASYNCPREFIX: identity,
+		PREFIXEDROUTINE: listOf,
+		PREFIXEDBLOCK: listOf,
+		PREFIX: sym,
+		ASYNCPREFIX: sym,
 		VARIADICCALL: func(function, params...) {
-			listStr("apply", function, ...params)
+			listForm("apply", function, ...params)
 		},
-		FUNCTIONCALL:	 listStr,
+		FUNCTIONCALL:	 listOf,
 		LEN: func(call) {
-			listStr("count", call)
+			listForm("count", call)
 		},
 		CHAN:		func() {
-			"(chan)"
+			listForm("chan")
 		} (n) {
-			listStr("chan", n)
+			listForm("chan", n)
 		},
-		EXPRESSIONLIST: blankJoin,
-		EXPRESSIONS:	blankJoin,
-		CONSTS:	blankJoin,
-		ASSIGNS:	blankJoin,
-		COMMACONSTS:	blankJoin,
+		EXPRESSIONLIST: splice,
+		EXPRESSIONS:	splice,
+		CONSTS:	splice,
+		ASSIGNS:	splice,
+		COMMACONSTS:	splice,
 		BLOCK: func (expr){
 			expr
 		} (expr0, exprRest...) {
-			str("(do ",  " "  s.join  (expr0  cons  exprRest),	")")
+			listForm("do", expr0, ...exprRest)
+		},
+		TYPECONVERSION: func(typ, expr) {
+			listOf(sym(typ), expr)
 		},
-		TYPECONVERSION: listStr,
-		INDEXED: func(xs, i){ listStr("nth", xs, i) },
-		TAKESLICE: func(xs, i){ listStr("take", i, xs) },
-		DROPSLICE: func(xs, i){ listStr("drop", i, xs) },
+		INDEXED: func(xs, i){ listForm("nth", xs, i) },
+		TAKESLICE: func(xs, i){ listForm("take", i, xs) },
+		DROPSLICE: func(xs, i){ listForm("drop", i, xs) },
 		TOPWITHCONST: declBlockFunc("let"),
 		TOPWITHASSIGN: declBlockFunc("let"),
 		WITHCONST: declBlockFunc("let"),
 		WITHASSIGN: declBlockFunc("let"),
 		LOOP:	   declBlockFunc("loop"),
-		CONST: func(identifier, expression) {
-			str(identifier, " ", expression)
-		},
-		ASSIGN: func(args...) {
+		CONST: splice,
+		ASSIGN: func(pos, args...) {
 			vArgs List := vec(args)
 			opPos      := vArgs->indexOf(":=")
 			n          := vArgs->size()
 			if  n % 2 != 1 || (n - 1) / 2 != opPos {
-				throw(new IOException(
-					"LHS and RHS of := do not  match"  str  blankJoin(vArgs)
-				))
+				addError(
+					"E0105", pos,
+					"LHS and RHS of := do not match: "  str  (" "  s.join  vArgs),
+					`put the same number of expressions on each side of :=`
+				)
+				splice()
 			} else {
-				" "  s.join  (for i := lazy \`range`(opPos) {
-					str(vArgs[i], " ", vArgs[opPos + 1 + i])
-				})
+				splice(...(for i := lazy \`range`(opPos) {
+					splice(vArgs[i], vArgs[opPos + 1 + i])
+				}))
 			}
 		},
-		VECDESTRUCT: vecStr,
-		DICTDESTRUCT: func{str('{', (" "  s.join  $*), "}")},
-		DICTDESTRUCTELEM: func(destruct, label) {
-			str(destruct, " ", label)
-		},
-		VARIADICDESTRUCT:  func{str("& ", $1)},
-		SYMBOL: func(identifier){
+		VECDESTRUCT: vecOf,
+		DICTDESTRUCT: mapOf,
+		DICTDESTRUCTELEM: splice,
+		VARIADICDESTRUCT:  func{splice(symbol("&"), $1)},
+		SYMBOL: func(pos, identifier){
 			identifier
-		} (pkg, identifier) {
-			if !(symbolTable  symbols.HasPackage  pkg) {
-				throw(new IOException(format(
-					`package "%s" in %s.%s does not appear in imports %s`,
-					pkg, pkg, identifier, symbols.Packages(symbolTable))))
+		} (pos, pkg, identifier) {
+			if !(symbolTable  symbols.HasPackage  str(pkg)) {
+				addError(
+					"E0101", pos,
+					format(
+						`package "%s" in %s.%s does not appear in imports %s`,
+						pkg, pkg, identifier, symbols.Packages(symbolTable)),
+					format(`import a package whose last path element is "%s"`, pkg)
+				)
 			}
-			str(pkg, "/", identifier)
-		},
-		BINARYOP: identity,
-		MULOP: identity,
-		ADDOP: identity,
-		RELOP: identity,
-		OPERATOR: identity,
+			symbol(str(pkg), str(identifier))
+		},
+		BINARYOP: sym,
+		MULOP: sym,
+		ADDOP: sym,
+		RELOP: sym,
+		OPERATOR: sym,
+		// The body of a declaration is generated before the declaration
+		// itself, so the types of the variables declared in it are
+		// dropped here, keeping them to the declaration.
 		FUNCTIONDECL:	func(identifier, function) {
-			defn := if isPublic(identifier) { "defn" } else { "defn-" }
-			listStr(defn, identifier, function)
+			defn := if IsPublic(identifier) { "defn" } else { "defn-" }
+			symbols.VariablesDropped(symbolTable)
+			listForm(defn, identifier, function)
 		},
 		FUNCLIKEDECL:	func(funclike, identifier, function) {
-			listStr(funclike, identifier, function)
+			symbols.VariablesDropped(symbolTable)
+			listOf(funclike, identifier, function)
 		},
-		FUNCTIONLIT:	func{listStr("fn", $1)},
+		FUNCTIONLIT:	func{listForm("fn", $1)},
 		SHORTFUNCTIONLIT:  func(expr) {
-			if first(expr) == '(' && last(expr) == ')' {
-				"#"  str  expr
+			if isSeq(expr) && !emitter.IsReaderMacro(expr) {
+				emitter.ShortFunction(withMeta(expr, nil))
 			}else{
-				listStr("fn", "[]", expr)
-		}
+				listForm("fn", [], expr)
+			}
 		},
-		STRUCTSPEC: func(javaIdentifier, fields...) {
+		// As in Go, a field that is only the name of a struct declared
+		// before embeds that struct, whose fields are promoted to this
+		// one unless it has a field of the same name.
+		// ClojureScript protocols do not expose their implementations,
+		// so in Goscript embedding a struct with methods is an error
+		// rather than leaving them out of the outer struct.
+		STRUCTSPEC: func(pos, javaIdentifier, fields...) {
+			declared     := vecOf(...fields)
+			embeddedType := func(field) {
+				if !get(meta(field), TAG) {
+					first(for typ := lazy symbols.Structs(symbolTable) if camelcaseToDashed(typ) == str(field) { typ })
+				}
+			}
+			embedded     := vec(keep(embeddedType, declared))
+			own          := set(remove(embeddedType, declared))
+			fieldForms   := vec(distinct(mapcat(func(field) {
+				if typ := embeddedType(field); typ {
+					remove(own, symbols.Fields(symbolTable, typ))
+				} else {
+					[field]
+				}
+			}, declared)))
+			for typ := range embedded {
+				if isGoscript && notEmpty(protocolsOf(symbolTable, typ)) {
+					addError(
+						"E0113", pos,
+						format(`the methods of %s cannot be promoted to %s in Goscript`, typ, javaIdentifier),
+						format(`give %s the methods itself, or call them on a %s made from its fields`, javaIdentifier, typ)
+					)
+				}
+			}
 			symbolTable  symbols.TypeCreated  javaIdentifier
-			listStr("defrecord",
-				javaIdentifier,
-				vecStr(...fields),
-				if isEmpty(fields) {
-					""
+			symbols.StructDeclared(symbolTable, javaIdentifier, fieldForms, embedded)
+			listForm("defrecord",
+				symbol(javaIdentifier),
+				fieldForms,
+				if isEmpty(fieldForms) {
+					splice()
 				} else {
-					fs     := fields[0]  s.split  / +/
-					fsOnly := func(s String){!s->startsWith("^")}  filter  fs
-					str(
-						"Object (toString [this] ",
-						listStr("str", `"{"`, ` " " `  s.join  fsOnly, `"}"`),
-						")"
+					names := for f := lazy fieldForms { withMeta(f, nil) }
+					splice(
+						symbol("Object"),
+						listForm("toString", [kThis],
+							listForm("str", "{", ...interpose(" ", names), "}"))
 					)
 				}
 			)
 		},
-		FIELDS: blankJoin,
-		INTERFACESPEC: func(args...){
-			symbolTable  symbols.TypeCreated  first(args)
-			listStr("defprotocol", ...args)
+		FIELDS: splice,
+		INTERFACESPEC: func(javaIdentifier, methodspecs...){
+			symbolTable  symbols.TypeCreated  javaIdentifier
+			symbols.InterfaceDeclared(symbolTable, javaIdentifier, signatures(methodspecs))
+			listForm("defprotocol", symbol(javaIdentifier), ...methodspecs)
 		},
 		VOIDMETHODSPEC: func(javaIdentifier) {
-			listStr(javaIdentifier, "[this]")
+			listOf(javaIdentifier, [kThis])
 		}(javaIdentifier, methodparams) {
-			listStr(javaIdentifier, str("[this ", methodparams, "]"))
+			listOf(javaIdentifier, vecOf(kThis, methodparams))
 		},
 		TYPEDMETHODSPEC: func(javaIdentifier, typ) {
-			listStr("^"  str  typ, javaIdentifier, "[this]")
+			listOf(hinted(javaIdentifier, typ), [kThis])
 		} (javaIdentifier, methodparams, typ) {
-			listStr("^"  str  typ, javaIdentifier, str("[this ", methodparams, "]"))
+			listOf(hinted(javaIdentifier, typ), vecOf(kThis, methodparams))
 		},
-		IMPLEMENTS: func(protocol, concrete, methodimpls...) {
+		IMPLEMENTS: func(pos, protocol, concrete, methodimpls...) {
 			symbolTable  symbols.TypeCreated  concrete
-			listStr(...concat(list("extend-type", concrete, protocol), methodimpls))
+			symbols.ProtocolImplemented(symbolTable, concrete, protocol)
+			checkImplements(pos, protocol, concrete, methodimpls)
+			symbols.VariablesDropped(symbolTable)
+			listForm("extend-type", symbol(concrete), protocol, ...methodimpls)
+		},
+		RECEIVER: func(typ) {
+			symbols.VariableTyped(symbolTable, str(kThis), str(typ))
+			[kThis, typ]
+		} (identifier, typ) {
+			symbols.VariableTyped(symbolTable, str(identifier), str(typ))
+			[identifier, typ]
+		},
+		METHODDECL: func(pos, receiver, javaIdentifier, function) {
+			[self, typ] := receiver
+			parts       := if isVector(first(function)) { [function] } else { function }
+			arglists    := for [params, body...] := lazy parts {
+				listOf(withMeta(vec(cons(self, params)), meta(params)), ...body)
+			}
+			if some(func{symbol("&")  isContains  set(first($1))}, parts) {
+				addError(
+					"E0108", pos,
+					format(`method %s of %s cannot take a variable number of arguments`, javaIdentifier, typ),
+					`pass the extra arguments as a single vector`
+				)
+			}
+			symbols.VariablesDropped(symbolTable)
+			listForm("extend-type", typ, methodProtocol(javaIdentifier),
+				if count(parts) == 1 {
+					listOf(symbol(javaIdentifier), ...first(arglists))
+				} else {
+					listOf(symbol(javaIdentifier), ...arglists)
+				}
+			)
 		},
 		METHODIMPL: func(javaIdentifier, function) {
-			listStr(javaIdentifier, function)
+			listOf(javaIdentifier, function)
 		},
-		METHODPARAMETERS: blankJoin,
+		METHODPARAMETERS: splice,
 		METHODPARAM: func(symbol) {
 			symbol
 		} (symbol, typ) {
-			str("^", typ, " ", symbol)
+			hinted(symbol, typ)
+		},
+		PERCENT: symbolFunc("%"),
+		PERCENTNUM: func{symbol("%"  str  $1)},
+		PERCENTVARADIC: symbolFunc("%&"),
+		FUNCTIONPARTS:	func(parts...) {
+			splice(...map(listOf, parts))
 		},
-		PERCENT: constantFunc("%"),
-		PERCENTNUM: func{"%"  str  $1},
-		PERCENTVARADIC: constantFunc("%&"),
-		FUNCTIONPARTS:	func{str("(",  ") ("  s.join  $*,	 ")")},
 		FUNCTIONPART0:	func(expression) {
-			"[] "  str  expression
+			splice([], expression)
 		} (typ, expression) {
-			str("^", typ, " [] ", expression)
+			splice(hinted([], typ), expression)
 		},
 		VFUNCTIONPART0:	 func(variadic, expression) {
-			str("[", variadic, "] ", expression)
+			splice(vecOf(variadic), expression)
 		} (variadic, typ, expression) {
-			str("^", typ, " [", variadic, "] ", expression)
+			splice(hinted(vecOf(variadic), typ), expression)
 		},
 		FUNCTIONPARTN:	func(parameters, expression) {
-			str("[", parameters, "] ", expression)
+			splice(vecOf(parameters), expression)
 		} (parameters, typ, expression) {
-			str("^", typ, " [", parameters, "] ", expression)
+			splice(hinted(vecOf(parameters), typ), expression)
 		},
 		VFUNCTIONPARTN: func(parameters, variadic, expression) {
-			str("[", parameters, " ", variadic, "] ", expression)
+			splice(vecOf(parameters, variadic), expression)
 		} (parameters, variadic, typ, expression) {
-			str("^", typ, " [", parameters, " ", variadic, "] ", expression)
+			splice(hinted(vecOf(parameters, variadic), typ), expression)
 		},
 		UNTYPEDMETHODIMPL: func(name, block) {
-			listStr(name, str("[this]"), block)
+			listOf(name, [kThis], block)
 		} (name, params, block) {
-			listStr(name, str("[this ", params, "]"), block)
+			listOf(name, vecOf(kThis, params), block)
 		},
 		TYPEDMETHODIMPL: func(name, typ, block) {
-			listStr("^"  str  typ, name, str("[this]"), block)
+			listOf(hinted(name, typ), [kThis], block)
 		} (name, params, typ, block) {
-			listStr("^"  str  typ, name, str("[this ", params, "]"), block)
-		},
-		PARAMETERS:	blankJoin,
-		VARIADIC:	func{"& "  str  $1},
-		VECLIT:		vecStr,
-		DICTLIT:	func{str(...$*)},
-		DICTELEMENT:	func(key, value) {str(key, " ", value, " ")},
-		SETLIT:		func{str("#{",	" "  s.join  $*,	"}")},
-		STRUCTLIT:	func(typ, exprs...) {
-			listStr(typ  str  ".", ...exprs)
-		},
-		LABEL:		func{":"  str  s.replace(s.lowerCase($1), /_/, "-")},
-		ISLABEL:	func{str(":", s.replace(s.lowerCase($1), /_/, "-"), "?")},
-		IDENTIFIER:	camelcaseToDashed,
+			listOf(hinted(name, typ), vecOf(kThis, params), block)
+		},
+		PARAMETERS:	splice,
+		VARIADIC:	func{splice(symbol("&"), $1)},
+		VECLIT:		vecOf,
+		DICTLIT:	func(open, elements...) {
+			// the elements are followed by the closing brace
+			mapOf(...butlast(elements))
+		},
+		DICTELEMENT:	splice,
+		SETLIT:		func(elements...) {
+			apply(hashSet, mapcat(forms, elements))
+		},
+		// The fields of a struct declared before in the file are known,
+		// so a literal of it can be checked, and an empty one sets them
+		// all to nil as a keyed one would.
+		STRUCTLIT:	func(pos, typ, exprs...) {
+			fields := symbols.Fields(symbolTable, str(typ))
+			switch {
+			case notEmpty(fields) && isEmpty(exprs):
+				listOf(symbol("map->"  str  typ), {})
+			case notEmpty(exprs) && fields && count(exprs) != count(fields):
+				addError(
+					"E0109", pos,
+					format(`%s values in literal of struct %s, which has fields [%s]`,
+						if count(exprs) < count(fields) { "too few" } else { "too many" },
+						typ, ", "  s.join  fields),
+					format(`give a value for each field, or name the fields as in %s{%s: value}`, typ, first(fields))
+				)
+				listOf(symbol(typ  str  "."), ...exprs)
+			default:
+				listOf(symbol(typ  str  "."), ...exprs)
+			}
+		},
+		// A keyed literal makes a record from a map of the fields given,
+		// so the others are nil.  If the struct was declared in the file
+		// the field names are checked and its map-> function is used,
+		// and otherwise the static create method of its class.
+		KEYEDSTRUCTLIT:	func(pos, typ, elements...) {
+			fields := symbols.Fields(symbolTable, str(typ))
+			names  := set(map(str, fields))
+			for [field, _] := range elements {
+				if fields && !(names  isContains  str(field)) {
+					addError(
+						"E0110", pos,
+						format(`unknown field %s in literal of struct %s`, field, typ),
+						format(`the fields of %s are [%s]`, typ, ", "  s.join  fields)
+					)
+				}
+			}
+			for [field, n] := range frequencies(map(first, elements)) {
+				if n > 1 {
+					addError("E0110", pos, format(`duplicate field %s in literal of struct %s`, field, typ))
+				}
+			}
+			listOf(
+				if fields { symbol("map->"  str  typ) } else { symbol(str(typ, "/create")) },
+				into({}, for [field, value] := lazy elements { [keyword(str(field)), value] })
+			)
+		},
+		KEYEDELEMENT:	vector,
+		LABEL:		func{keyword(s.replace(s.lowerCase($1), /_/, "-"))},
+		ISLABEL:	func{keyword(str(s.replace(s.lowerCase($1), /_/, "-"), "?"))},
+		IDENTIFIER:	func(idf) {
+			switch idf {
+			case "true":  true
+			case "false": false
+			case "nil":   nil
+			default:      symbol(camelcaseToDashed(idf))
+			}
+		},
 		TYPEDIDENTIFIER: func(identifier, typ) {
-			str(`^`, typ, " ", identifier)
+			symbols.VariableTyped(symbolTable, str(identifier), str(typ))
+			hinted(identifier, typ)
 		},
 		TYPEDIDENTIFIERS: func(args...) {
 			typ         := last(args)
 			identifiers := butlast(args)
-			decls       := for identifier := lazy identifiers {
-					str(`^`, typ, " ", identifier)
-			}
-			blankJoin(...decls)
+			splice(...(for identifier := lazy identifiers {
+				hinted(identifier, typ)
+			}))
 		},
 		PKG: func{"."  s.join  $*},
-		DECIMALLIT:    identity,
-		BIGINTLIT:     str,
-		BIGFLOATLIT:   str,
-		FLOATLIT:      identity, //str,
+		DECIMALLIT:    readString,
+		OCTALLIT:      readString,
+		BIGINTLIT:     func(n, suffix) { bigint(n) },
+		BIGFLOATLIT:   func(x, suffix) { bigdec(x) },
+		FLOATLIT:      func(s string){
+				Double::parseDouble(s)
+		},
 		HEXLIT:        func(s string){
 				Integer::parseInt(s, 16)
 		},
 		REGEX:	func(regex string){
-			str(
-				`#"`,
-				stripQuotes(regex)->replace(`\/`, `/`)->replace(`"`, `\"`),
-				`"`
-			)
+			rePattern(stripQuotes(regex)->replace(`\/`, `/`))
 		},
 		INTERPRETEDSTRINGLIT: func(literal) {
-			str(`"`, stripQuotes(literal), `"`)
-		},
-		RAWSTRINGLIT: func{str(`"`, s.escape($1, charEscapeString), `"`)},
-		CLOJUREESCAPE: identity,
-		LITTLEUVALUE:  func(d1,d2,d3,d4){str(`\u`,d1,d2,d3,d4)},
-		OCTALBYTEVALUE:	 func(d1,d2,d3){str(`\o`,d1,d2,d3)},
-		UNICODECHAR:   func{`\`  str  $1},
-		NEWLINECHAR:   constantFunc(`\newline`),
-		SPACECHAR:     constantFunc(`\space`),
-		BACKSPACECHAR: constantFunc(`\backspace`),
-		RETURNCHAR:    constantFunc(`\return`),
-		TABCHAR:       constantFunc(`\tab`),
-		BACKSLASHCHAR: constantFunc(`\\`),
-		SQUOTECHAR:    constantFunc(`\'`),
-		DQUOTECHAR:    constantFunc(`\"`),
+			readString(str(`"`, stripQuotes(literal), `"`))
+		},
+		RAWSTRINGLIT: identity,
+		// Clojure code is passed through as it is, like an escaped identifier
+		CLOJUREESCAPE: symbol,
+		LITTLEUVALUE:  func(d1,d2,d3,d4){char(Integer::parseInt(str(d1,d2,d3,d4), 16))},
+		OCTALBYTEVALUE:	 func(d1,d2,d3){char(Integer::parseInt(str(d1,d2,d3), 8))},
+		UNICODECHAR:   func{first($1)},
+		NEWLINECHAR:   constantly('\n'),
+		SPACECHAR:     constantly(' '),
+		BACKSPACECHAR: constantly('\b'),
+		RETURNCHAR:    constantly('\r'),
+		TABCHAR:       constantly('\t'),
+		BACKSLASHCHAR: constantly('\\'),
+		SQUOTECHAR:    constantly('\''),
+		DQUOTECHAR:    constantly('\"'),
 		HEXDIGIT:      identity,
 		OCTALDIGIT:    identity,
 		ISIDENTIFIER:	func(initial, identifier) {
-			str( s.lowerCase(initial), identifier, "?")
+			symbol(str( s.lowerCase(initial), identifier, "?"))
 		},
-		EQUALS: constantFunc("="),
-		AND:	constantFunc("and"),
-		OR:	constantFunc("or"),
+		EQUALS: symbolFunc("="),
+		AND:	symbolFunc("and"),
+		OR:	symbolFunc("or"),
 		MUTIDENTIFIER:	func(initial, identifier) {
-			str( s.lowerCase(initial), identifier, "!")
+			symbol(str( s.lowerCase(initial), identifier, "!"))
 		},
-		ESCAPEDIDENTIFIER:  func{ stripQuotes($1) },
+		ESCAPEDIDENTIFIER:  func{ symbol(stripQuotes($1)) },
 		UNARYEXPR: func(e) {
 			e
 		} (operator, expression){
-			listStr(operator, expression)
-		},
-		NOTEQ:	     constantFunc("not="),
-		BITAND:	     constantFunc("bit-and"),
-		BITANDNOT:	     constantFunc("bit-and-not"),
-		BITOR:	     constantFunc("bit-or"),
-		BITXOR:	     constantFunc("bit-xor"),
-		BITNOT:	     constantFunc("bit-not"),
-		TAKE:	     constantFunc("<!!"),
-		TAKEINGO:    constantFunc("<!"),
-		SENDOP:      constantFunc(">!!"),
-		SENDOPINGO:  constantFunc(">!"),
-		SHIFTLEFT:   constantFunc("bit-shift-left"),
-		SHIFTRIGHT:  constantFunc("bit-shift-right"),
-		NOT:	     constantFunc("not"),
-		MOD:	     constantFunc("mod"),
-		DEREF:		 func{"@"    str  $1},
-		SYNTAXQUOTE:	 func{"`"    str  $1},
-		UNQUOTE:	 func{"~"    str  $1},
-		UNQUOTESPLICING: func{ "~@"  str  $1},
+			listOf(sym(operator), expression)
+		},
+		NOTEQ:	     symbolFunc("not="),
+		BITAND:	     symbolFunc("bit-and"),
+		BITANDNOT:	     symbolFunc("bit-and-not"),
+		BITOR:	     symbolFunc("bit-or"),
+		BITXOR:	     symbolFunc("bit-xor"),
+		BITNOT:	     symbolFunc("bit-not"),
+		TAKE:	     symbolFunc("<!!"),
+		TAKEINGO:    symbolFunc("<!"),
+		SENDOP:      symbolFunc(">!!"),
+		SENDOPINGO:  symbolFunc(">!"),
+		SHIFTLEFT:   symbolFunc("bit-shift-left"),
+		SHIFTRIGHT:  symbolFunc("bit-shift-right"),
+		NOT:	     symbolFunc("not"),
+		MOD:	     symbolFunc("mod"),
+		DEREF:		 func{list(symbol("clojure.core", "deref"), $1)},
+		SYNTAXQUOTE:	 emitter.SyntaxQuote,
+		UNQUOTE:	 func{list(symbol("clojure.core", "unquote"), $1)},
+		UNQUOTESPLICING: func{list(symbol("clojure.core", "unquote-splicing"), $1)},
 		JAVAFIELD:	func(expression, identifier) {
-			listStr(".", expression, identifier)
+			listForm(".", expression, sym(identifier))
 		},
-		JAVASTATIC:	 func{"/"  s.join  $*},
-		TYPENAME:	 func(segments...){
+		JAVASTATIC:	 func(typ, identifier) {
+			symbol(str(typ), str(identifier))
+		},
+		TYPENAME:	 func(pos, segments...){
 			typ := "."  s.join  segments
 			if !hasType(typ) {
-				throw(new IOException(format(
-					`type "%s" does not appear in type imports %s`,
-					typ, symbols.Types(symbolTable))))
+				addError(
+					"E0102", pos,
+					format(
+						`type "%s" does not appear in type imports %s`,
+						typ, symbols.Types(symbolTable)),
+					...typeImportHints(typ)
+				)
 			}
-			typ
+			symbol(typ)
 		},
 		UNDERSCOREJAVAIDENTIFIER: func(s string){ "-"  str  s->substring(1)},
-		JAVAMETHODCALL: func(expression, identifier) {
-			str("(. ", expression, " (", identifier, "))")
-		} (expression, identifier, call) {
-			str("(. ", expression, " (", identifier, " ", call, "))")
-		},
-		LONG: constantFunc("long"),
-		DOUBLE: constantFunc("double"),
-		STRING: constantFunc("String")
-	}
+		JAVAMETHODCALL: func(pos, expression, identifier) {
+			methodCall(pos, expression, identifier, [])
+		} (pos, expression, identifier, call) {
+			methodCall(pos, expression, identifier, [call])
+		},
+		LONG: symbolFunc("long"),
+		DOUBLE: symbolFunc("double"),
+		STRING: symbolFunc("String")
+	})
 }
 
 func syncImports(isGoscript, isSync) {
@@ -677,14 +1223,14 @@ func syncImports(isGoscript, isSync) {
 		[]
 	} else {
 		if isGoscript {
-			[vecStr(
-				"cljs.core.async", ":as", "async", ":refer",
-				"[chan <! >! alt!]"
+			[vecOf(
+				symbol("cljs.core.async"), AS, symbol("async"), REFER,
+				vec(map(symbol, ["chan", "<!", ">!", "alt!"]))
 			)]
 		} else {
-			[vecStr(
-				"clojure.core.async", ":as", "async", ":refer",
-				"[chan go thread <! >! alt! <!! >!! alt!!]"
+			[vecOf(
+				symbol("clojure.core.async"), AS, symbol("async"), REFER,
+				vec(map(symbol, ["chan", "go", "thread", "<!", ">!", "alt!", "<!!", ">!!", "alt!!"]))
 			)]
 		}
 	}
@@ -694,51 +1240,61 @@ func macroSyncImports(isGoscript, isSync) {
 	if isSync || !isGoscript {
 		[]
 	} else {
-		[vecStr("cljs.core.async.macros", ":as", "async", ":refer", "[go]")]
+		[vecOf(symbol("cljs.core.async.macros"), AS, symbol("async"), REFER, [symbol("go")])]
 	}
 }
 
-// Return an empty list if tail is empty, otherwise return listStr(head, ...tail)
+// Return an empty list if tail is empty, otherwise return a list of
+// the list of head followed by tail.
 func req(head, tail) {
 	if isEmpty(tail) {
 		[]
 	} else {
-		[listStr(head, ...tail)]
+		[listOf(head, ...tail)]
 	}
 }
 
+// Return whether any of the clauses of an ns form is of the given kind.
+func hasClause(clauses, kind) {
+	some(func{first($1) == kind}, clauses)
+}
+
 func packageclauseFunc(symbolTable, path String, isGoscript, isSync) {
 	[parent, name] := splitPath(path)
 	if isGoscript {
 		symbolTable  symbols.PackageCreated  "js"
 	}
-	func(imported, importDecls String) {
-		fullImported     := parent  str  imported
-		hasImports       := importDecls->contains(":require ")
-		hasMacroImports  := importDecls->contains(":require-macros ")
-		xtraImports      := if hasImports {
+	func(pos, imported, importDecls) {
+		fullImported     := symbol(parent  str  imported)
+		clauses          := forms(importDecls)
+		xtraImports      := if hasClause(clauses, REQUIRE) {
 			[]
 		} else {
-			req(":require", syncImports(isGoscript, isSync))
+			req(REQUIRE, syncImports(isGoscript, isSync))
 		}
-		xtraMacroImports := if hasMacroImports {
+		xtraMacroImports := if hasClause(clauses, REQUIRE_MACROS) {
 			[]
 		} else {
-			req(":require-macros", macroSyncImports(isGoscript, isSync))
+			req(REQUIRE_MACROS, macroSyncImports(isGoscript, isSync))
 		}
-		imports          := concat([importDecls], xtraMacroImports, xtraImports)
+		imports          := concat(clauses, xtraMacroImports, xtraImports)
 		if imported != name {
-			throw(new IOException(str(
-				`Got package "`, imported, `" instead of expected "`,
-				name, `" in "`, path, `"`
-			)))
+			symbols.AddDiagnostic(symbolTable, diagnostic.Error(
+				"E0107", path, pos,
+				str(
+					`Got package "`, imported, `" instead of expected "`,
+					name, `" in "`, path, `"`
+				),
+				str("change the package clause to: package ", name)
+			))
 		}
 		if isGoscript {
-			listStr("ns", fullImported, ...imports)
+			splice(listForm("ns", fullImported, ...imports), methodProtocols(symbolTable))
 		} else {
-			str(
-				listStr("ns", fullImported, "(:gen-class)", ...imports),
-				" (set! *warn-on-reflection* true)"
+			splice(
+				listForm("ns", fullImported, list(GEN_CLASS), ...imports),
+				listForm("set!", symbol("*warn-on-reflection*"), true),
+				methodProtocols(symbolTable)
 			)
 		}
 	}
@@ -746,19 +1302,19 @@ func packageclauseFunc(symbolTable, path String, isGoscript, isSync) {
 
 func importDeclFunc(isGoscript, isSync) {
 	func() {
-		""
+		splice()
 	} (importSpecs...) {
 		imports := importSpecs  concat  syncImports(isGoscript, isSync)
-		listStr(":require", ...imports)
+		listOf(REQUIRE, ...imports)
 	}
 }
 
 func macroImportDeclFunc(isGoscript, isSync) {
 	func() {
-		""
+		splice()
 	} (importSpecs...) {
 		imports := importSpecs  concat  macroSyncImports(isGoscript, isSync)
-		listStr(":require-macros", ...imports)
+		listOf(REQUIRE_MACROS, ...imports)
 	}
 }
 
@@ -782,17 +1338,193 @@ func usesAsync(parsed) {
 	}
 }
 
-// Return the Clojure code generated from the given parse tree.
+// Return a function that converts a character index in source into
+// a one-based {LINE, COLUMN} position.
+func Locator(source String) {
+	newlines := vec(for [i, c] := lazy mapIndexed(vector, source) if c == '\n' { i })
+	func(index) {
+		found     := Collections::binarySearch(newlines, long(index))
+		line      := if found < 0 { -found - 1 } else { found }
+		lineStart := if line == 0 { 0 } else { newlines[line - 1] + 1 }
+		{LINE: line + 1, COLUMN: index - lineStart + 1}
+	}
+}
+
+// Return the tree of node with the position given by the function
+// position inserted as the first child of each node that needs it.
+func positionedTree(position, isQuoted, node) {
+	if isVector(node) {
+		[tag, children...] := node
+		walked             := func{positionedTree(position, isQuoted || tag == SYNTAXQUOTE, $1)}  map  children
+		positioned         := switch {
+		case kLocatedRules  isContains  tag:
+			[tag, if !isQuoted { position(node) }]  concat  walked
+		case kCheckedRules  isContains  tag:
+			[tag, position(node)]  concat  walked
+		default:
+			tag  cons  walked
+		}
+		vec(positioned)
+	} else {
+		node
+	}
+}
+
+// Insert the source position of every node whose rule is in
+// kLocatedRules or kCheckedRules as its first child.  The position is
+// that of the first token of the node, not of any space before it.
+// It is nil if the source is not known, or if the node is in
+// kLocatedRules and inside a syntax quote, where metadata is not
+// wanted.  The parser's metadata is dropped, so that it is not merged
+// into the generated forms.
+func withPositions(source, parsed) {
+	locate   := if isNil(source) { constantly(nil) } else { Locator(source) }
+	position := func(node) {
+		if span := insta.span(node); span && source {
+			[tokenStart, end] := comments.TokenSpan(source, node)
+			startPos          := locate(tokenStart)
+			endPos            := locate(end)
+			startPos += {END_LINE: endPos(LINE), END_COLUMN: endPos(COLUMN)}
+		}
+	}
+	positionedTree(position, false, parsed)
+}
+
+// The heads of the generated forms that can be given a docstring.
+kDocumented := set{
+	symbol("defn"), symbol("defn-"), symbol("def"), symbol("defprotocol"), symbol("defrecord")
+}
+
+// Matches the first line of a comment that is only slashes or stars,
+// like the lines framing the licence banner at the top of a file.
+kBanner := /^[\/*]*$/
+
+// Return whether the top-level form is a definition that can be given
+// a docstring.  A def can only if it has an initial value.
+func isDocumentable(form) {
+	isSeq(form) && (kDocumented  isContains  first(form)) && (first(form) != symbol("def") || count(form) == 3)
+}
+
+// Return the comments, of those in aloneByEnd keyed by the line they
+// end on, in the block of whole-line comments that ends on the line
+// before line.
+func commentBlock(aloneByEnd, line) {
+	loop(line = line, block = list()) {
+		if c := aloneByEnd(line - 1); c {
+			recur(c(LINE), c  cons  block)
+		} else {
+			block
+		}
+	}
+}
+
+// Return the comments in the last block of whole-line comments before
+// the package clause on packageLine, other than a banner starting with
+// a line of slashes or stars.  Blank lines may separate it from the
+// package clause.
+func packageCommentBlock(found, packageLine) {
+	alone  := for c := lazy found if c(ALONE) && c(LINE) < packageLine { c }
+	blocks := reduce(func(acc, c) {
+		if notEmpty(acc) && c(LINE) == last(last(acc))(END_LINE) + 1 {
+			pop(acc)  conj  (last(acc)  conj  c)
+		} else {
+			acc  conj  [c]
+		}
+	}, [], alone)
+	last(for block := lazy blocks if !reFind(kBanner, first(s.splitLines(first(block)(TEXT)))) { block })
+}
+
+// Return the docstring made from the block of comments, less the space
+// that usually follows each // and starts each line of a /* */ comment.
+func docstring(block) {
+	s.trim("\n"  s.join  for c := lazy block { s.replace(c(TEXT), /(?m)^ /, "") })
+}
+
+// Return the form with the docstring doc, which for a defrecord, which
+// takes no docstring, is the DOC metadata of its name.
+func withDocstring(form, doc) {
+	if first(form) == symbol("defrecord") {
+		withMeta(list(first(form), varyMeta(second(form), assoc, DOC, doc), ...drop(2, form)), meta(form))
+	} else {
+		withMeta(list(...concat(take(2, form), [doc], drop(2, form))), meta(form))
+	}
+}
+
+// Return the top-level forms with the comments found in their source
+// by comments.Find attached.  A block of whole-line comments directly
+// above a definition becomes its docstring, and the last block before
+// the package clause on packageLine, if it is known, becomes that of
+// the ns form, which is first.  Any other comment before the package
+// clause is attached to the ns form.  Any other comment after it is
+// attached to the nearest form that carries its source position: the
+// next such form if the comment is alone at the start of its line, or
+// else the one it is in or follows.  A comment is attached by adding
+// its text to the vector of texts in the COMMENTS metadata of the form.
+func withComments(forms, found, packageLine) {
+	aloneByEnd  := into({}, for c := lazy found if c(ALONE) { [c(END_LINE), c] })
+	lines       := vec(for form := lazy forms { get(meta(form), LINE) })
+	located     := for i := lazy range(count(forms)) if lines[i] { i }
+	nsDocs      := if packageLine { {0: packageCommentBlock(found, packageLine)} } else { {} }
+	docs        := into(nsDocs, for i := lazy located if isDocumentable(forms[i]) {
+		[i, commentBlock(aloneByEnd, lines[i])]
+	})
+	documented  := set(apply(concat, vals(docs)))
+	nearest     := func(c) {
+		if packageLine && c(LINE) < packageLine {
+			0
+		} else {
+			before := last(for i := lazy located if lines[i] <= c(LINE) { i })
+			after  := first(for i := lazy located if lines[i] > c(LINE) { i })
+			if c(ALONE) && c(COLUMN) == 1 { after || before } else { before || after }
+		}
+	}
+	attached    := groupBy(nearest, for c := lazy found if !(documented  isContains  c) { c })
+	vec(mapIndexed(func(i, form) {
+		texts     := vec(mapcat(func{s.splitLines($1(TEXT))}, get(attached, i)))
+		block     := get(docs, i)
+		commented := if notEmpty(texts) { varyMeta(form, assoc, COMMENTS, texts) } else { form }
+		if notEmpty(block) {
+			withDocstring(commented, docstring(block))
+		} else {
+			commented
+		}
+	}, forms))
+}
+
+// Return the top-level Clojure forms generated from the given parse
+// tree, or throw an exception carrying the diagnostics of all the
+// errors found in it.  If the source it was parsed from is given, the diagnostics
+// give positions in the source, and if isLocated is true the
+// generated forms carry metadata giving their position in the source.
+// If isLocated is true the comments in the source are also attached
+// to the forms.  Unused imports are errors unless isCheckingImports is
+// false.
 func Generate(path String, parsed, isSync) {
+	Generate(path, parsed, isSync, nil)
+} (path String, parsed, isSync, source) {
+	Generate(path, parsed, isSync, source, !isNil(source))
+} (path String, parsed, isSync, source, isLocated) {
+	Generate(path, parsed, isSync, source, isLocated, true)
+} (path String, parsed, isSync, source, isLocated, isCheckingImports) {
 	symbolTable := symbols.New()
+	found       := if isLocated && source { comments.Find(source, parsed, Locator(source)) }
+	packageLine := if found && first(parsed) == SOURCEFILE {
+		Locator(source)(first(comments.TokenSpan(source, second(parsed))))(LINE)
+	}
 	isGoscript  := path->endsWith(".gos")
 	isSync      := !usesAsync(parsed)
-	codeGen     := codeGenerator(symbolTable, isGoscript) += {
+	codeGen     := codeGenerator(symbolTable, isGoscript, path, isLocated) += {
 		PACKAGECLAUSE:   packageclauseFunc(symbolTable, path, isGoscript, isSync),
 		IMPORTDECL:      importDeclFunc(isGoscript, isSync) ,
 		MACROIMPORTDECL: macroImportDeclFunc(isGoscript, isSync)
 	}
-	clj         := insta.transform(codeGen, parsed)
-	symbols.CheckAllUsed(symbolTable)
-	clj
+	declareMethods(symbolTable, parsed)
+	generated   := insta.transform(codeGen, withPositions(source, parsed))
+	if isCheckingImports {
+		symbols.CheckAllUsed(symbolTable, path)
+	}
+	if diagnostics := symbols.Diagnostics(symbolTable); notEmpty(diagnostics) {
+		diagnostic.Throw(...diagnostics)
+	}
+	withComments(forms(generated), found, packageLine)
 }
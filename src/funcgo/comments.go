@@ -0,0 +1,112 @@
+//////
+// This file is part of the Funcgo compiler.
+//
+// Copyright (c) 2014 Eamonn O'Brien-Strain All rights
+// reserved. This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License v1.0 which
+// accompanies this distribution, and is available at
+// http://www.eclipse.org/legal/epl-v10.html
+//
+// Contributors:
+// Eamonn O'Brien-Strain e@obrain.com - initial author
+//////
+
+// Finding the comments in Funcgo source.  The parser treats comments
+// as whitespace, so they are found by scanning the text, after masking
+// out the literals in which // and /* do not start a comment.
+
+package comments
+import (
+	insta "instaparse/core"
+	"clojure/string"
+)
+import type (
+	java.util.regex.Matcher
+)
+
+// The rules of the tokens whose text is left exactly as it is, and in
+// which brackets and slashes are not code.
+kOpaqueRules := set{
+	INTERPRETEDSTRINGLIT, RAWSTRINGLIT, CLOJUREESCAPE, REGEX, ESCAPEDIDENTIFIER,
+	UNICODECHAR, NEWLINECHAR, SPACECHAR, BACKSPACECHAR, RETURNCHAR,
+	TABCHAR, BACKSLASHCHAR, SQUOTECHAR, DQUOTECHAR, OCTALBYTEVALUE,
+	LITTLEUVALUE
+}
+
+// Matches the space and comments that a parse tree node's span may
+// start with before its first token.
+kLeadingSpace := /(?:\s|\/\/[^\n]*\n|\/\*[\s\S]*?\*\/)+/
+
+// Matches a line comment or a block comment in text whose literals
+// have been masked out.
+kComment := /\/\/[^\n]*|\/\*[\s\S]*?\*\//
+
+// Return the span of the node, starting at its first token rather
+// than at any space before it.
+func TokenSpan(text String, node) {
+	[start, end]    := insta.span(node)
+	matcher Matcher := reMatcher(kLeadingSpace, text)
+	if matcher->region(start, end)->lookingAt() {
+		[matcher->end(), end]
+	} else {
+		[start, end]
+	}
+}
+
+// Return the spans of the tokens in the parse tree whose text must not
+// be changed.
+func OpaqueSpans(text, tree) {
+	if isVector(tree) {
+		if kOpaqueRules  isContains  first(tree) {
+			[TokenSpan(text, tree)]
+		} else {
+			mapcat(func{OpaqueSpans(text, $1)}, rest(tree))
+		}
+	}
+}
+
+// Return text with every character of the spans other than newlines
+// replaced by x, so that what is left can be scanned for brackets and
+// comments.
+func Masked(text String, spans) {
+	builder := new StringBuilder(text)
+	for i := range mapcat(func{range(first($1), second($1))}, spans) {
+		if builder->charAt(i) != '\n' {
+			builder->setCharAt(i, 'x')
+		}
+	}
+	builder->toString()
+}
+
+// Return the spans of the comments in the text parsed into tree, in
+// order.
+func Spans(text String, tree) {
+	matcher Matcher := reMatcher(kComment, Masked(text, OpaqueSpans(text, tree)))
+	loop(acc = []) {
+		if matcher->find() {
+			recur(acc  conj  [matcher->start(), matcher->end()])
+		} else {
+			acc
+		}
+	}
+}
+
+// Return the comments in the text parsed into tree, in order, each as
+// a map of the LINE and COLUMN where it starts and the END_LINE where
+// it ends, given by the function locate of an index in text, its TEXT
+// without the // or /* */ around it, and whether it is ALONE on its
+// line, with only space before it.
+func Find(text String, tree, locate) {
+	vec(for [start, end] := lazy Spans(text, tree) {
+		startPos       := locate(start)
+		comment String := subs(text, start, end)
+		isLine         := comment->startsWith("//")
+		{
+			LINE:     startPos(LINE),
+			COLUMN:   startPos(COLUMN),
+			END_LINE: locate(end)(LINE),
+			TEXT:     if isLine { subs(comment, 2) } else { subs(comment, 2, count(comment) - 2) },
+			ALONE:    string.isBlank(subs(text, start - startPos(COLUMN) + 1, start))
+		}
+	})
+}
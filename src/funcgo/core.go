@@ -14,31 +14,73 @@
 package  core
 import (
         insta "instaparse/core"
+        "clojure/java/io"
         "clojure/pprint"
         "instaparse/failure"
         "clojure/string"
 	"funcgo/parser"
 	"funcgo/codegen"
+	"funcgo/diagnostic"
+	"funcgo/emitter"
+	"funcgo/newlines"
+	"funcgo/syntaxerror"
 )
-import type java.io.IOException
+import type (
+	clojure.lang.{ExceptionInfo, Namespace}
+	java.io.{File, FileNotFoundException, PushbackReader, StringReader}
+	java.util.regex.Matcher
+)
+
+// After a parse failure, the most top-level declarations that are
+// blanked out to look for further syntax errors.
+kMaxRecoveries := 20
 
+// The options of ParseForms and LoadString that are not given.
+kDefaultOptions := {SYNC: false, LOCATED: true}
 
-func untabify(s){      string.replace(s, /\t/,           "        ") }
+// Matches Funcgo source that starts with a package clause.
+kPackageClause := /^(?:\s|\/\/[^\n]*\n|\/\*[\s\S]*?\*\/)*package\b/
+
+// Matches the start of each top-level declaration or expression,
+// which starts a line with something other than space, a comment or a
+// closing bracket.
+kTopLevelStart := /(?m)^[^\s\/)}\]]/
 
 func Ambiguity(fgo) {
-  preprocessed := untabify(fgo)
-  insta.parses(parser.Parse, preprocessed)
+  insta.parses(parser.Parse, newlines.Blanked(fgo))
+}
+
+// Return the directory that files for debugging parse failures are
+// written to, which is given by the funcgo.debug.dir system property,
+// or nil if no such files are to be written.
+func DebugDir() {
+	System::getProperty("funcgo.debug.dir")
+}
+
+// Write the given description of how parsing the file at path failed
+// to the debug directory, if there is one.
+func writeDebugFile(path, description) {
+	if debugDir := DebugDir(); debugDir {
+		file File := io.file(path)
+		dir  File := io.file(debugDir)
+		dir->mkdirs()
+		io.file(dir, str("__failure_", file->getName(), ".txt"))  spit  description
+	}
 }
 
-func parse(preprocessed, startRule, isAmbiguity) {
+// Parse the Funcgo code fgo from startRule, with the newlines that do
+// not end a statement taken as space.  If isAmbiguity is true, warn if
+// there is more than one parse.
+func parse(path, fgo, startRule, isAmbiguity) {
 	if isAmbiguity {
 
-		parsedList := insta.parses(parser.Parse, preprocessed, START, startRule)
+		parsedList := insta.parses(parser.Parse, newlines.Blanked(fgo), START, startRule)
 		ambiguity := count(parsedList)
 		switch ambiguity {
 		case 0: {
-			"__preprocessed.go"  spit  preprocessed
-			throw(new IOException(
+			writeDebugFile(path, "no parse")
+			diagnostic.Throw(diagnostic.Error(
+				"E0001", path, nil,
 				"Parsing failure.  Turn off ambiguity flag to see details."))
 		}
 		case 1:
@@ -51,26 +93,240 @@ func parse(preprocessed, startRule, isAmbiguity) {
 
 	} else {
 
-		parsed := parser.Parse(preprocessed, START, startRule)
+		parsed := parser.Parse(newlines.Blanked(fgo), START, startRule)
 		if insta.isFailure(parsed) {
-			"__preprocessed.go"  spit  preprocessed
-			throw(new IOException(str(withOutStr(failure.pprintFailure(parsed)))))
+			writeDebugFile(path, withOutStr(failure.pprintFailure(parsed)))
+		}
+		parsed
+
+	}
+}
+
+// Return the indexes in text of the starts of the top-level
+// declarations and expressions, skipping the lines that start inside
+// a comment or a literal.
+func topLevelStarts(text String) {
+	spans           := newlines.OpaqueSpans(text)
+	matcher Matcher := reMatcher(kTopLevelStart, text)
+	loop(acc = []) {
+		if matcher->find() {
+			start := matcher->start()
+			if some(func{ first($1) < start && start < second($1) }, spans) {
+				recur(acc)
+			} else {
+				recur(acc  conj  start)
+			}
 		} else {
-			parsed
+			acc
 		}
+	}
+}
 
+// Replace the text between start and end with spaces, keeping the
+// newlines so that the lines of the rest of the text do not change.
+func blankOut(text String, start, end) {
+	str(
+		subs(text, 0, start),
+		string.replace(subs(text, start, end), /[^\n]/, " "),
+		subs(text, end)
+	)
+}
+
+// Recover from the parse failure of the source fgo by repeatedly
+// blanking out the top-level declaration or expression where parsing
+// failed and parsing again.  Return the
+// DIAGNOSTICS of the syntax errors found, and the tree PARSED from
+// what is left, or nil if nothing could be parsed.  Failures at the
+// end of the file after something has been blanked out are not
+// reported, because they are usually caused by the earlier errors,
+// and nothing after a block comment that is never terminated is
+// parsed, because it was all meant to be commented out.
+func recoverFrom(path, fgo String, startRule, failure) {
+	starts := topLevelStarts(fgo)
+	loop(
+		text        = fgo,
+		failed      = failure,
+		blanked     = set{},
+		diagnostics = [syntaxerror.Diagnostic(path, fgo, fgo, failure)]
+	) {
+		index := get(failed, INDEX)
+		chunk := last(for i := lazy range(count(starts)) if starts[i] <= index && !(blanked  isContains  i) { i })
+		start := if chunk { starts[chunk] }
+		if isNil(chunk) || count(blanked) >= kMaxRecoveries || get(last(diagnostics), CODE) == "E0002" ||
+			fgo->startsWith("package", start) || fgo->startsWith("import", start) {
+			{DIAGNOSTICS: diagnostics, PARSED: nil}
+		} else {
+			end      := if chunk + 1 < count(starts) { starts[chunk + 1] } else { count(text) }
+			newText  := blankOut(text, start, end)
+			reparsed := parser.Parse(newlines.Blanked(newText), START, startRule)
+			if insta.isFailure(reparsed) {
+				recur(newText, reparsed, blanked  conj  chunk,
+					if string.isBlank(subs(newText, get(reparsed, INDEX))) {
+						diagnostics
+					} else {
+						diagnostics  conj  syntaxerror.Diagnostic(path, fgo, newText, reparsed)
+					}
+				)
+			} else {
+				{DIAGNOSTICS: diagnostics, PARSED: reparsed}
+			}
+		}
+	}
+}
+
+// Throw the diagnostics of all the syntax errors found by recovering
+// from the parse failure, together with those of any semantic errors
+// in what could be parsed, other than unused imports, which may have
+// been used in what was blanked out.
+func throwFailures(path, fgo, startRule, isSync, failure) {
+	{diagnostics: DIAGNOSTICS, parsed: PARSED} := recoverFrom(path, fgo, startRule, failure)
+	semantic := if parsed {
+		try {
+			codegen.Generate(path, parsed, isSync, fgo, false, false)
+			[]
+		} catch ExceptionInfo e {
+			diagnostic.Of(path, e)
+		}
+	} else {
+		[]
 	}
+	diagnostic.Throw(...(juxt(LINE, COLUMN)  sortBy  concat(diagnostics, semantic)))
 }
 
+// Return the top-level Clojure forms compiled from the Funcgo code
+// fgo.  If isLocated is true the forms carry metadata giving their
+// line and column in fgo.  Problems are thrown as an exception
+// carrying diagnostics (see funcgo/diagnostic).  After a syntax error
+// the parser skips to the next top-level declaration, so that all the
+// errors in the file are reported together.
+func Forms(path, fgo) {
+	Forms(path, fgo, SOURCEFILE)
+} (path, fgo, startRule) {
+	Forms(path, fgo, startRule, false, false, false)
+} (path, fgo, startRule, isNodes, isSync, isAmbiguity) {
+	Forms(path, fgo, startRule, isNodes, isSync, isAmbiguity, false)
+} (path, fgo, startRule, isNodes, isSync, isAmbiguity, isLocated) {
+	parsed := parse(path, fgo, startRule, isAmbiguity)
+	if isNodes {
+		pprint.pprint(parsed)
+	}
+	if insta.isFailure(parsed) {
+		throwFailures(path, fgo, startRule, isSync, parsed)
+	}
+	codegen.Generate(path, parsed, isSync, fgo, isLocated)
+}
+
+// Return the Clojure code compiled from the Funcgo code fgo, on one
+// line.  The arguments are as for Forms.
 func Parse(path, fgo) {
 	Parse(path, fgo, SOURCEFILE)
 } (path, fgo, startRule) {
 	Parse(path, fgo, startRule, false, false, false)
 } (path, fgo, startRule, isNodes, isSync, isAmbiguity) {
-	preprocessed := untabify(fgo)
-	parsed := parse(preprocessed, startRule, isAmbiguity)
-	if isNodes {
-		pprint.pprint(parsed)
+	Parse(path, fgo, startRule, isNodes, isSync, isAmbiguity, false)
+} (path, fgo, startRule, isNodes, isSync, isAmbiguity, isLocated) {
+	emitter.Emit(Forms(path, fgo, startRule, isNodes, isSync, isAmbiguity, isLocated))
+}
+
+// Return the parser rule to start parsing the Funcgo source with:
+// SOURCEFILE if it has a package clause and NONPKGFILE otherwise.
+func StartRule(source) {
+	if reFind(kPackageClause, source) { SOURCEFILE } else { NONPKGFILE }
+}
+
+// Return the parse tree of the Funcgo code fgo, or throw an exception
+// carrying the diagnostics of its syntax errors.
+func ParseTree(path, fgo, startRule) {
+	parsed := parse(path, fgo, startRule, false)
+	if insta.isFailure(parsed) {
+		throwFailures(path, fgo, startRule, false, parsed)
+	}
+	parsed
+}
+
+// Return the forms compiled from source with the given options of
+// ParseForms.
+func formsWith(path, source, opts) {
+	{start: START, isSync: SYNC, isLocated: LOCATED} := kDefaultOptions  merge  opts
+	Forms(path, source, if start { start } else { StartRule(source) }, false, isSync, false, isLocated)
+}
+
+// Return the Clojure forms compiled from the Funcgo source, as read by
+// the Clojure reader in the current namespace, with metadata giving
+// their positions in the source.  The opts map may give the START rule,
+// which by default is SOURCEFILE if the source has a package clause
+// and NONPKGFILE otherwise, whether the code is SYNC, and whether the
+// forms are LOCATED.
+func ParseForms(path, source) {
+	ParseForms(path, source, {})
+} (path, source, opts) {
+	doall(for form := lazy formsWith(path, source, opts) {
+		readString(emitter.Emit([form]))
+	})
+}
+
+// Return the namespace target, or the namespace named by the symbol
+// target, creating it with clojure.core referred if need be.
+func targetNamespace(target) {
+	if isInstance(Namespace, target) {
+		target
+	} else {
+		if existing := findNs(target); existing {
+			existing
+		} else {
+			created := createNs(target)
+			withBindings({findVar(symbol("clojure.core", "*ns*")): created}, referClojure())
+			created
+		}
+	}
+}
+
+// Evaluate the Clojure text compiled from the Funcgo file at path in
+// the namespace target (a namespace or a symbol), returning the value
+// of the last top-level form.  Each form is read in the namespace left
+// by the one before, so code with a package clause is evaluated in its
+// own namespace, as when loading a Clojure file.  The forms keep the
+// source positions in their metadata, which the compiler uses for
+// line numbers in stack traces.
+func LoadCompiled(path, clj, target) {
+	reader := new PushbackReader(new StringReader(clj))
+	withBindings(
+		{
+			findVar(symbol("clojure.core", "*ns*")):                 targetNamespace(target),
+			findVar(symbol("clojure.core", "*warn-on-reflection*")): false,
+			findVar(symbol("clojure.core", "*file*")):               path,
+			findVar(symbol("clojure.core", "*source-path*")):        io.file(path)->getName()
+		},
+		loop(value = nil) {
+			form := read(reader, false, reader)
+			if isIdentical(form, reader) {
+				value
+			} else {
+				recur(eval(form))
+			}
+		}
+	)
+}
+
+// Compile the Funcgo source and evaluate it in the namespace target, as
+// by LoadCompiled.  The path, which is relative to the source root, is
+// used in diagnostics and to check the package clause.  The opts are
+// as for ParseForms.
+func LoadString(path, source, target) {
+	LoadString(path, source, target, {})
+} (path, source, target, opts) {
+	LoadCompiled(path, emitter.Emit(formsWith(path, source, opts)), target)
+}
+
+// Compile the Funcgo file at path, which is relative to the source
+// root, and evaluate it as by LoadString.  As for a Clojure file given
+// to load, the file is looked up on the classpath.
+func LoadFile(path, target) {
+	LoadFile(path, target, {})
+} (path, target, opts) {
+	if url := io.resource(path); url {
+		LoadString(path, slurp(url), target, opts)
+	} else {
+		throw(new FileNotFoundException(path  str  " is not on the classpath"))
 	}
-	codegen.Generate(path, parsed, isSync)
 }
@@ -0,0 +1,105 @@
+//////
+// This file is part of the Funcgo compiler.
+//
+// Copyright (c) 2014 Eamonn O'Brien-Strain All rights
+// reserved. This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License v1.0 which
+// accompanies this distribution, and is available at
+// http://www.eclipse.org/legal/epl-v10.html
+//
+// Contributors:
+// Eamonn O'Brien-Strain e@obrain.com - initial author
+//////
+
+// A diagnostic is a map describing a problem the compiler found in
+// Funcgo source.  It has a SEVERITY (ERROR or WARNING), a stable
+// CODE, the FILE, the one-based LINE and COLUMN where the problem
+// starts, the END_LINE and END_COLUMN just past where it ends, a
+// MESSAGE, and a possibly empty vector of HINTS suggesting fixes.
+// The position fields are nil if the position is not known.  It may
+// also have an EXCERPT, the source line with a caret line under it
+// pointing at the problem.
+//
+// The codes are:
+//   E0000  any other failure
+//   E0001  syntax error
+//   E0002  block comment not terminated
+//   E0101  package not imported
+//   E0102  type not imported
+//   E0103  package imported and not used
+//   E0104  type imported and not used
+//   E0105  different number of values on each side of :=
+//   E0106  different identifiers in c-style for loop
+//   E0107  package clause does not match the file name
+//   E0108  method with a receiver is variadic
+//   E0109  wrong number of values in struct literal
+//   E0110  unknown or duplicate field in keyed struct literal
+//   E0111  implements does not match the methods of the interface
+//   E0112  method call may be of a method with a receiver or Java interop
+//   E0113  methods of an embedded struct cannot be promoted in Goscript
+//   E0201  output file exists and was not written by the compiler
+
+package diagnostic
+import (
+	"clojure/string"
+	"funcgo/json"
+)
+
+// Return a new diagnostic.  The position pos is a map with LINE,
+// COLUMN, END_LINE and END_COLUMN, or nil if not known.
+func New(severity, code, file, pos, message, hints) {
+	{
+		SEVERITY:   severity,
+		CODE:       code,
+		FILE:       file,
+		LINE:       get(pos, LINE),
+		COLUMN:     get(pos, COLUMN),
+		END_LINE:   get(pos, END_LINE),
+		END_COLUMN: get(pos, END_COLUMN),
+		MESSAGE:    message,
+		HINTS:      vec(hints)
+	}
+}
+
+// Return a new error diagnostic.
+func Error(code, file, pos, message, hints...) {
+	New(ERROR, code, file, pos, message, hints)
+}
+
+// Throw an exception carrying the given diagnostics.  Its message is
+// made of their messages, one per line.
+func Throw(diagnostics...) {
+	message := "\n"  string.join  (MESSAGE  map  diagnostics)
+	throw(exInfo(message, {DIAGNOSTICS: vec(diagnostics)}))
+}
+
+// Return the diagnostics carried by the exception, or if it does not
+// carry any, a single diagnostic made from its message.
+func Of(file, e Throwable) {
+	if diagnostics := get(exData(e), DIAGNOSTICS); diagnostics {
+		diagnostics
+	} else {
+		[Error("E0000", file, nil, str(e->getMessage()))]
+	}
+}
+
+// Return the diagnostic in the same "file:line:column: message" form
+// as the Go compiler, with any excerpt and hints on following lines.
+func Format(diagnostic) {
+	{file: FILE, line: LINE, column: COLUMN, message: MESSAGE} := diagnostic
+	location := switch {
+	case isNil(line):   file
+	case isNil(column): str(file, ":", line)
+	default:            str(file, ":", line, ":", column)
+	}
+	heading  := str(location, ": ", name(diagnostic(SEVERITY)),
+		"[", diagnostic(CODE), "]: ", message)
+	excerpt  := if e := diagnostic(EXCERPT); e { "\n"  str  e } else { "" }
+	hints    := for hint := lazy diagnostic(HINTS) { "\n\thint: "  str  hint }
+	str(heading, excerpt, string.join(hints))
+}
+
+// Return the diagnostic as a single line of JSON.
+func Json(diagnostic) {
+	json.Write(diagnostic)
+}
@@ -0,0 +1,161 @@
+//////
+// This file is part of the Funcgo compiler.
+//
+// Copyright (c) 2014 Eamonn O'Brien-Strain All rights
+// reserved. This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License v1.0 which
+// accompanies this distribution, and is available at
+// http://www.eclipse.org/legal/epl-v10.html
+//
+// Contributors:
+// Eamonn O'Brien-Strain e@obrain.com - initial author
+//////
+
+// Writing of the Clojure forms generated by the compiler as Clojure
+// source text.  The forms are ordinary Clojure data, except that a
+// syntax quote or a short function literal, which the Clojure reader
+// expands away, is a list headed by a symbol in this namespace.
+// Metadata is written as reader metadata.
+
+package emitter
+import (
+	"clojure/pprint"
+	"clojure/string"
+)
+
+kSyntaxQuote    := symbol("funcgo.emitter", "syntax-quote")
+kShortFunction  := symbol("funcgo.emitter", "short-function")
+
+// The heads of the two-element lists that are written as reader
+// macros, and the prefix each is written with.
+kReaderMacros := {
+	kSyntaxQuote:                             "`",
+	kShortFunction:                           "#",
+	symbol("clojure.core", "deref"):            "@",
+	symbol("clojure.core", "unquote"):          "~",
+	symbol("clojure.core", "unquote-splicing"): "~@"
+}
+
+kCharNames := {
+	'\n': "newline",
+	' ':  "space",
+	'\t': "tab",
+	'\b': "backspace",
+	'\r': "return"
+}
+
+// Return the form that is written as a syntax quote of form.
+func SyntaxQuote(form) {
+	list(kSyntaxQuote, form)
+}
+
+// Return the form that is written as a short function literal whose
+// body is the list form.
+func ShortFunction(form) {
+	list(kShortFunction, form)
+}
+
+// Return whether form is written with a reader macro prefix.
+func IsReaderMacro(form) {
+	isSeq(form) && count(form) == 2 && (kReaderMacros  isContains  first(form))
+}
+
+// Return the Clojure literal of the character c, using an escape for
+// anything other than printable ASCII so that the output is plain
+// text whatever the encoding.
+func charLiteral(c Character) {
+	code := int(c)
+	if name := kCharNames(c); name {
+		`\`  str  name
+	} else {
+		if code > 32 && code < 127 {
+			`\`  str  c
+		} else {
+			format(`\u%04x`, code)
+		}
+	}
+}
+
+// Return the reader metadata prefixes for the metadata of form: the
+// source position, then any private flag and type hint, then anything
+// else other than the comments attached to the form.
+func metaPrefix(form) {
+	met      := meta(form)
+	position := selectKeys(met, [LINE, COLUMN, FILE])
+	others   := dissoc(met, LINE, COLUMN, FILE, PRIVATE, TAG, COMMENTS)
+	str(
+		if notEmpty(position) { str("^", prStr(position), " ") },
+		if get(met, PRIVATE) { "^:private " },
+		if tag := get(met, TAG); tag { str("^", tag, " ") },
+		if notEmpty(others) { str("^", prStr(others), " ") }
+	)
+}
+
+func emit(form) {
+	str(metaPrefix(form), switch {
+	case IsReaderMacro(form):
+		kReaderMacros(first(form))  str  emit(second(form))
+	case isSeq(form):
+		str("(", " "  string.join  map(emit, form), ")")
+	case isVector(form):
+		str("[", " "  string.join  map(emit, form), "]")
+	case isMap(form):
+		str("{", " "  string.join  map(emit, apply(concat, form)), "}")
+	case isSet(form):
+		str("#{", " "  string.join  map(emit, form), "}")
+	case isChar(form):
+		charLiteral(form)
+	case isSymbol(form):
+		str(form)
+	default:
+		prStr(form)
+	})
+}
+
+// Return the Clojure source text of the given top-level forms, all on
+// one line.
+func Emit(forms) {
+	" "  string.join  map(emit, forms)
+}
+
+// Pretty-print the form to writer.  This is a version of pprint that
+// preserves type hints and reader macros, but not the source
+// positions or comments, which the caller can instead preserve by
+// where it puts the form and by writing the comments before it.
+// See https://groups.google.com/forum/#!topic/clojure/5LRmPXutah8
+func Pprint(form, writer) {
+	origDispatch := \pprint/*print-pprint-dispatch*\          // */ for emacs
+	pprint.withPprintDispatch(
+		func(o) {
+			if met := notEmpty(dissoc(meta(o), LINE, COLUMN, FILE, COMMENTS)); met {
+				print("^")
+				if count(met) == 1 {
+					if met(TAG) {
+						origDispatch(met(TAG))
+					} else {
+						if met(PRIVATE) == true {
+							origDispatch(PRIVATE)
+						} else {
+							origDispatch(met)
+						}
+					}
+				} else {
+					origDispatch(met)
+				}
+				print(" ")
+				pprint.pprintNewline(FILL)
+			}
+			switch {
+			case IsReaderMacro(o): {
+				print(kReaderMacros(first(o)))
+				pprint.writeOut(second(o))
+			}
+			case isChar(o):
+				print(charLiteral(o))
+			default:
+				origDispatch(o)
+			}
+		},
+		pprint.pprint(form, writer)
+	)
+}
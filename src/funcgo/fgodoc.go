@@ -0,0 +1,242 @@
+//////
+// This file is part of the Funcgo compiler.
+//
+// Copyright (c) 2014 Eamonn O'Brien-Strain All rights
+// reserved. This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License v1.0 which
+// accompanies this distribution, and is available at
+// http://www.eclipse.org/legal/epl-v10.html
+//
+// Contributors:
+// Eamonn O'Brien-Strain e@obrain.com - initial author
+//////
+
+// The fgodoc command, which writes the API documentation of Funcgo
+// packages, in the spirit of godoc.  The documentation of a package
+// lists the names it exports, as determined by codegen.IsPublic, with
+// how to use each and the docstring made from its doc comment.
+
+package fgodoc
+import (
+	"clojure/java/io"
+	"clojure/string"
+	"clojure/tools/cli"
+	"funcgo/codegen"
+	"funcgo/core"
+	"funcgo/diagnostic"
+	"funcgo/manifest"
+)
+import type (
+	clojure.lang.ExceptionInfo
+	java.io.File
+	java.nio.file.Path
+)
+
+commandLineOptions := [
+	["-f", "--format FORMAT", "write the documentation as markdown or html",
+		DEFAULT, "markdown",
+		VALIDATE, [func{$1 == "markdown" || $1 == "html"}, "must be markdown or html"]],
+	["-o", "--out-dir DIR", "directory to write the documentation in",
+		DEFAULT, "doc"],
+	["-h", "--help", "print help"]
+]
+
+// The extension of the files written in each format.
+kExtensions := {"markdown": ".md", "html": ".html"}
+
+// Return the Funcgo spelling of the Clojure name of an identifier,
+// undoing the conversion of camelcase to dashes.
+func funcgoName(name) {
+	string.replace(string.replace(name, /^-/, "_"), /(?<=\p{Ll})-(\p{Ll})/, func{string.upperCase($1[1])})
+}
+
+// Return the docstring of the top-level form, or nil if it has none.
+// A defrecord takes no docstring, so its docstring is the DOC
+// metadata of its name.
+func docOf(form) {
+	head := str(first(form))
+	doc  := nth(form, 2, nil)
+	switch head {
+	case "defrecord":
+		get(meta(second(form)), DOC)
+	case "def":
+		if count(form) == 4 { doc }
+	default:
+		if isString(doc) { doc }
+	}
+}
+
+// Return the Funcgo signature of the function with the given name and
+// Clojure argument vector.
+func signature(name, arglist) {
+	[fixed, variadic] := splitWith(func{$1 != symbol("&")}, arglist)
+	args              := concat(
+		for arg := lazy fixed { funcgoName(prStr(arg)) },
+		for arg := lazy rest(variadic) { funcgoName(prStr(arg))  str  "..." }
+	)
+	str(funcgoName(name), "(", ", "  string.join  args, ")")
+}
+
+// Return the Funcgo signatures of the interface with the given name
+// and method specs, which are those of a defprotocol.
+func interfaceSignatures(name, specs) {
+	methods := for spec := lazy specs if isSeq(spec) {
+		arglist := first(filter(isVector, rest(spec)))
+		"\t"  str  signature(str(first(spec)), rest(arglist))
+	}
+	concat([str("type ", funcgoName(name), " interface {")], methods, ["}"])
+}
+
+// Return the Funcgo signatures showing how to use what the top-level
+// form defines, or nil if it is not a definition.
+func signatures(form) {
+	nam := str(second(form))
+	switch str(first(form)) {
+	case "defn", "defmacro":
+		for arglist := lazy manifest.Arglists(drop(2, form)) { "func "  str  signature(nam, arglist) }
+	case "def":
+		[str("var ", funcgoName(nam))]
+	case "defrecord":
+		[str("type ", funcgoName(nam), " struct {", ", "  string.join  map(str, nth(form, 2)), "}")]
+	case "defprotocol":
+		interfaceSignatures(nam, drop(2, form))
+	}
+}
+
+// Return a description of what the top-level form exports, or nil if
+// it is not a public definition: the Funcgo NAME, the SIGNATURES, and
+// the DOC.
+func exported(form) {
+	if isSeq(form) && isSymbol(second(form)) && codegen.IsPublic(second(form)) && !get(meta(second(form)), PRIVATE) {
+		if sigs := signatures(form); sigs {
+			{
+				NAME:       funcgoName(str(second(form))),
+				SIGNATURES: vec(sigs),
+				DOC:        docOf(form)
+			}
+		}
+	}
+}
+
+// Return the documentation of the package compiled to the top-level
+// forms: its NAMESPACE, its DOC, and the descriptions of what it
+// EXPORTS, in the order they are defined.
+func Package(forms) {
+	nsForm := first(forms)
+	{
+		NAMESPACE: str(second(nsForm)),
+		DOC:       docOf(nsForm),
+		EXPORTS:   vec(keep(exported, rest(forms)))
+	}
+}
+
+// Return the documentation of the package in Markdown.
+func Markdown(pkg) {
+	str(
+		"# package ", pkg(NAMESPACE), "\n\n",
+		if doc := pkg(DOC); doc { str(doc, "\n\n") },
+		apply(str, for e := lazy pkg(EXPORTS) {
+			str(
+				"## ", e(NAME), "\n\n",
+				"```go\n", "\n"  string.join  e(SIGNATURES), "\n```\n\n",
+				if doc := e(DOC); doc { str(doc, "\n\n") }
+			)
+		})
+	)
+}
+
+// Return the text with the characters special to HTML escaped.
+func escaped(text) {
+	string.escape(str(text), {'&': "&amp;", '<': "&lt;", '>': "&gt;", '"': "&quot;"})
+}
+
+// Return the docstring as HTML paragraphs, which are separated by
+// blank lines.
+func paragraphs(doc) {
+	apply(str, for p := lazy string.split(doc, /\n\s*\n/) { str("<p>", escaped(p), "</p>\n") })
+}
+
+// Return the documentation of the package as an HTML page.
+func Html(pkg) {
+	title := escaped(pkg(NAMESPACE))
+	str(
+		"<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n",
+		"<title>package ", title, "</title>\n</head>\n<body>\n",
+		"<h1>package ", title, "</h1>\n",
+		if doc := pkg(DOC); doc { paragraphs(doc) },
+		apply(str, for e := lazy pkg(EXPORTS) {
+			str(
+				"<h2 id=\"", escaped(e(NAME)), "\">", escaped(e(NAME)), "</h2>\n",
+				"<pre>", escaped("\n"  string.join  e(SIGNATURES)), "</pre>\n",
+				if doc := e(DOC); doc { paragraphs(doc) }
+			)
+		}),
+		"</body>\n</html>\n"
+	)
+}
+
+// Return the path of file relative to the directory dir, with forward
+// slashes as in a URL.
+func relativePath(dir File, file File) {
+	dirPath  Path := dir->getAbsoluteFile()->toPath()->normalize()
+	filePath Path := file->getAbsoluteFile()->toPath()->normalize()
+	string.replace(str(dirPath->relativize(filePath)), File::separator, "/")
+}
+
+// Write the documentation of the Funcgo file, whose path relative to
+// the root of its source tree is relative, in the format given by the
+// options.  Files without a package clause are skipped.
+func documentFile(file File, relative, opts) {
+	source := slurp(file)
+	try {
+		if core.StartRule(source) == SOURCEFILE {
+			pkg     := Package(core.Forms(relative, source, SOURCEFILE, false, false, false, true))
+			outFile := io.file(opts(OUT_DIR), pkg(NAMESPACE)  str  kExtensions(opts(FORMAT)))
+			io.makeParents(outFile)
+			spit(outFile, if opts(FORMAT) == "html" { Html(pkg) } else { Markdown(pkg) })
+			println("  ", relative, "-->", outFile->getPath())
+		}
+	} catch ExceptionInfo e {
+		for d := range diagnostic.Of(relative, e) {
+			println(diagnostic.Format(d))
+		}
+	}
+}
+
+// Write the documentation of the Funcgo files given, or in the
+// directories given.
+func documentAll(paths, opts) {
+	for path := range paths {
+		root File := io.file(path)
+		for f := range fileSeq(root) {
+			file File := f
+			if file->isFile() && reFind(/\.gos?$/, file->getName()) {
+				documentFile(file, if root->isDirectory() { relativePath(root, file) } else { path }, opts)
+			}
+		}
+	}
+}
+
+// Write the API documentation of Funcgo packages, using the
+// commandLineOptions to parse the arguments.
+func Doc(args...) {
+	cmdLine   := args  cli.parseOpts  commandLineOptions
+	otherArgs := cmdLine(ARGUMENTS)
+	opts      := cmdLine(OPTIONS)
+	if cmdLine(ERRORS) || opts(HELP) || !seq(otherArgs) {
+		if cmdLine(ERRORS) {
+			println(cmdLine(ERRORS))
+		}
+		println("USAGE:  fgodoc [options] path ...")
+		println("options:")
+		println(cmdLine(SUMMARY))
+	} else {
+		documentAll(otherArgs, opts)
+	}
+}
+
+// Entry point for the stand-alone fgodoc command.  Usage is the same
+// as for the Doc function.
+func _main(args...) {
+	Doc(...args)
+}
@@ -0,0 +1,262 @@
+//////
+// This file is part of the Funcgo compiler.
+//
+// Copyright (c) 2014 Eamonn O'Brien-Strain All rights
+// reserved. This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License v1.0 which
+// accompanies this distribution, and is available at
+// http://www.eclipse.org/legal/epl-v10.html
+//
+// Contributors:
+// Eamonn O'Brien-Strain e@obrain.com - initial author
+//////
+
+// Formatting of Funcgo source in canonical style, in the spirit of
+// gofmt.  Only the whitespace between tokens changes, so comments are
+// kept as they are:
+//   - lines are indented with one tab per level of brackets opened on
+//     earlier lines, with case and default clauses one level out, and
+//     lines continuing a || or && expression one level in
+//   - an infix function call has exactly two spaces on either side
+//     of the function
+//   - trailing whitespace and repeated blank lines are removed
+// The text of string, character and regular expression literals and
+// of /* */ comments is never touched, even if it spans lines.
+
+package formatter
+import (
+	insta "instaparse/core"
+	"clojure/string"
+	"funcgo/comments"
+	"funcgo/core"
+	"funcgo/diagnostic"
+)
+import type (
+	java.util.regex.Matcher
+)
+
+kOpeners := set{'(', '[', '{'}
+kClosers := set{')', ']', '}'}
+
+// Matches the space after the left operand or the function of an infix
+// call, if it is all on one line.
+kInfixSpace := /[ \t]++(?![\r\n]|\/\/)/
+
+// Matches a comment in a line whose literals have been masked out.
+kComment := /\/\/.*/
+
+// Matches the closing brackets at the start of a line.
+kLeadingClosers := /^[)}\]\s]*/
+
+kCaseClause := /^(?:case\b|default\s*:)/
+
+// Matches the end of a line that a || or && expression continues
+// after.  The operator cannot start the next line, because the newline
+// would end the statement.
+kContinued := /(?:\|\||&&)$/
+
+// Return the positions in text where the space after the left operand
+// or the function of an infix call starts.
+func infixGaps(tree) {
+	if isVector(tree) {
+		gaps := mapcat(infixGaps, rest(tree))
+		if first(tree) == PRECEDENCE0 && count(tree) == 4 {
+			[_, left, function, _] := tree
+			concat([second(insta.span(left)), second(insta.span(function))], gaps)
+		} else {
+			gaps
+		}
+	}
+}
+
+// Return the edits that make the space after each infix operand and
+// function exactly two spaces.
+func infixEdits(text String, tree) {
+	matcher Matcher := reMatcher(kInfixSpace, text)
+	for gap := lazy infixGaps(tree) if matcher->region(gap, count(text))->lookingAt() {
+		[gap, matcher->end(), "  "]
+	}
+}
+
+// Return the stack of the lines of the brackets still open after the
+// code, given the stack of those open before it.
+func scanBrackets(stack, code, lineNo) {
+	reduce(func(open, c) {
+		switch {
+		case kOpeners  isContains  c:
+			open  conj  lineNo
+		case (kClosers  isContains  c) && notEmpty(open):
+			pop(open)
+		default:
+			open
+		}
+	}, stack, code)
+}
+
+// Return the number of tabs to indent a line with, given the lines of
+// the brackets open at its start, its code, and the code of the line
+// before.
+func indentLevel(open, content, prevCode) {
+	isCase      := reFind(kCaseClause, content) && notEmpty(open)
+	isContinued := reFind(kContinued, prevCode)
+	count(distinct(open)) + (if isContinued { 1 } else { 0 }) - (if isCase { 1 } else { 0 })
+}
+
+// Return the edits that indent each line canonically, remove trailing
+// whitespace and remove repeated blank lines.  Lines that start inside
+// a literal are not indented.
+func lineEdits(text String, spans) {
+	insideNewlines := set(for i := lazy mapcat(func{range(first($1), second($1))}, spans) if text[i] == '\n' { i })
+	lines          := map(vector, string.split(text, /\n/, -1), string.split(comments.Masked(text, spans), /\n/, -1))
+	loop(lines = lines, offset = 0, lineNo = 0, stack = [], prevCode = "", wasBlank = true, edits = []) {
+		if isEmpty(lines) {
+			edits
+		} else {
+			[line, mask]  := first(lines)
+			lineEnd       := offset + count(line)
+			isInside      := insideNewlines  isContains  (offset - 1)
+			code          := string.trimr(string.replace(mask, kComment, ""))
+			content       := string.triml(code)
+			closers       := reFind(kLeadingClosers, content)
+			open          := vec(dropLast(count(filter(kClosers, closers)), stack))
+			isBlank       := string.isBlank(mask) && !isInside
+			level         := indentLevel(open, content, prevCode)
+			leading       := count(mask) - count(string.triml(mask))
+			trailing      := count(mask) - count(string.trimr(mask))
+			edited        := switch {
+			case isBlank && wasBlank:
+				[[offset, min(lineEnd + 1, count(text)), ""]]
+			case isBlank:
+				[[offset, lineEnd, ""]]
+			case isInside:
+				[[lineEnd - trailing, lineEnd, ""]]
+			default:
+				[
+					[offset, offset + leading, apply(str, repeat(level, "\t"))],
+					[lineEnd - trailing, lineEnd, ""]
+				]
+			}
+			recur(
+				rest(lines),
+				lineEnd + 1,
+				lineNo + 1,
+				scanBrackets(open, subs(content, count(closers)), lineNo),
+				if isBlank { prevCode } else { code },
+				isBlank,
+				edits  into  edited
+			)
+		}
+	}
+}
+
+// Return text with the edits, each a vector of the start and end of
+// the text to replace and what to replace it with, applied.  The edits
+// must not overlap.
+func applyEdits(text String, edits) {
+	builder := new StringBuilder(text)
+	for [start, end, replacement] := range sortBy(func{-first($1)}, edits) {
+		builder->replace(int(start), int(end), replacement)
+	}
+	builder->toString()
+}
+
+// Return the Funcgo source in canonical style.  Throws an exception
+// carrying diagnostics if the source has syntax errors, or if
+// formatting it would change its parse tree, which would be a bug in
+// the formatter.
+func Format(path, source) {
+	Format(path, source, core.StartRule(source))
+} (path, source String, startRule) {
+	tree      := core.ParseTree(path, source, startRule)
+	spans     := concat(
+		comments.OpaqueSpans(source, tree),
+		for span := lazy comments.Spans(source, tree) if source->startsWith("/*", first(span)) { span }
+	)
+	edits     := concat(infixEdits(source, tree), lineEdits(source, spans))
+	formatted := string.trimr(applyEdits(source, edits))  str  "\n"
+	if core.ParseTree(path, formatted, startRule) != tree {
+		diagnostic.Throw(diagnostic.Error(
+			"E0000", path, nil, "formatting would change the meaning of the code"))
+	}
+	formatted
+}
+
+// Return the script of the edits that turn the lines before into the
+// lines after, as a sequence of pairs of SAME, DELETE or INSERT and a
+// line, found from their longest common subsequence.
+func editScript(before, after) {
+	n     := count(before)
+	m     := count(after)
+	// table[i][j] is the length of the longest common subsequence of
+	// the lines of before from i and the lines of after from j.
+	table := loop(i = n - 1, rows = list(vec(repeat(m + 1, 0)))) {
+		if i < 0 {
+			vec(rows)
+		} else {
+			below := first(rows)
+			row   := loop(j = m - 1, acc = list(0)) {
+				if j < 0 {
+					vec(acc)
+				} else {
+					recur(j - 1, cons(
+						if before[i] == after[j] { below[j + 1] + 1 } else { max(below[j], first(acc)) },
+						acc
+					))
+				}
+			}
+			recur(i - 1, cons(row, rows))
+		}
+	}
+	loop(i = 0, j = 0, acc = []) {
+		switch {
+		case i < n && j < m && before[i] == after[j]:
+			recur(i + 1, j + 1, acc  conj  [SAME, before[i]])
+		case j < m && (i == n || table[i][j + 1] >= table[i + 1][j]):
+			recur(i, j + 1, acc  conj  [INSERT, after[j]])
+		case i < n:
+			recur(i + 1, j, acc  conj  [DELETE, before[i]])
+		default:
+			acc
+		}
+	}
+}
+
+// Return the range of n lines starting at the given line, as given in
+// a hunk header of a unified diff, where an empty range is given by the
+// line before it.
+func hunkRange(line, n) {
+	format("%d,%d", if n == 0 { line - 1 } else { line }, n)
+}
+
+// Return the differences between the texts before and after of the
+// file at path, in unified diff format without context lines, or nil
+// if there are none.
+func Diff(path, before, after) {
+	script := editScript(vec(string.splitLines(before)), vec(string.splitLines(after)))
+	hunks  := loop(runs = partitionBy(func{first($1) == SAME}, script), oldLine = 1, newLine = 1, acc = []) {
+		if isEmpty(runs) {
+			acc
+		} else {
+			run      := first(runs)
+			deleted  := for [op, line] := lazy run if op == DELETE { line }
+			inserted := for [op, line] := lazy run if op == INSERT { line }
+			recur(
+				rest(runs),
+				oldLine + count(run) - count(inserted),
+				newLine + count(run) - count(deleted),
+				if first(first(run)) == SAME {
+					acc
+				} else {
+					acc  conj  str(
+						format("@@ -%s +%s @@\n", hunkRange(oldLine, count(deleted)), hunkRange(newLine, count(inserted))),
+						apply(str, for line := lazy deleted { str("-", line, "\n") }),
+						apply(str, for line := lazy inserted { str("+", line, "\n") })
+					)
+				}
+			)
+		}
+	}
+	if notEmpty(hunks) {
+		str("--- ", path, "\n+++ ", path, "\n", apply(str, hunks))
+	}
+}
@@ -0,0 +1,57 @@
+//////
+// This file is part of the Funcgo compiler.
+//
+// Copyright (c) 2014 Eamonn O'Brien-Strain All rights
+// reserved. This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License v1.0 which
+// accompanies this distribution, and is available at
+// http://www.eclipse.org/legal/epl-v10.html
+//
+// Contributors:
+// Eamonn O'Brien-Strain e@obrain.com - initial author
+//////
+
+// Minimal JSON output, for the files and messages the compiler
+// produces for other tools.
+
+package json
+import "clojure/string"
+
+func quoted(s String) {
+	escaped := string.escape(s, func(c) {
+		switch c {
+		case '"':  `\"`
+		case '\\': `\\`
+		case '\n': `\n`
+		case '\r': `\r`
+		case '\t': `\t`
+		default:   if int(c) < 32 { format(`\u%04x`, int(c)) }
+		}
+	})
+	str(`"`, escaped, `"`)
+}
+
+// Return the JSON text for a value made of maps, sequences, strings,
+// keywords, numbers, booleans and nils.
+func Write(value) {
+	switch {
+	case isNil(value):
+		"null"
+	case isString(value):
+		quoted(value)
+	case isKeyword(value):
+		quoted(name(value))
+	case isNumber(value) || isTrue(value) || isFalse(value):
+		str(value)
+	case isMap(value): {
+		members := for [k, v] := lazy value {
+			str(Write(if isKeyword(k) { name(k) } else { str(k) }), ":", Write(v))
+		}
+		str("{", ","  string.join  members, "}")
+	}
+	case isColl(value):
+		str("[", ","  string.join  (Write  map  value), "]")
+	default:
+		quoted(str(value))
+	}
+}
@@ -0,0 +1,105 @@
+//////
+// This file is part of the Funcgo compiler.
+//
+// Copyright (c) 2014 Eamonn O'Brien-Strain All rights
+// reserved. This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License v1.0 which
+// accompanies this distribution, and is available at
+// http://www.eclipse.org/legal/epl-v10.html
+//
+// Contributors:
+// Eamonn O'Brien-Strain e@obrain.com - initial author
+//////
+
+// A hook into clojure.core/load, so that require finds Funcgo source
+// files on the classpath and compiles them in memory, without needing
+// a .clj file generated beforehand.
+
+package loader
+import (
+	"clojure/java/io"
+	"funcgo/core"
+	"funcgo/manifest"
+)
+
+// The Clojure code compiled from each Funcgo source that has been
+// loaded, keyed by a hash of the path and the source.
+var cache = ref({})
+
+// The clojure.core/load function that was replaced by Install, or nil
+// if it has not been replaced.
+var original = ref(nil)
+
+// Return the Clojure code compiled from the Funcgo source of the file
+// at path, compiling it only if the same source has not been compiled
+// before.
+func compiled(path, source) {
+	key := manifest.Hash(path  str  "\n"  str  source)
+	if clj := (*cache)(key); clj {
+		clj
+	} else {
+		fresh := core.Parse(path, source, SOURCEFILE, false, false, false, true)
+		dosync(alter(cache, assoc, key, fresh))
+		fresh
+	}
+}
+
+// Return the URL of the Funcgo source file to load instead of the
+// resource that clojure.core/load would load for the given path, or
+// nil if there is none.  Only paths relative to the classpath root,
+// which are what require uses, are looked up.  A compiled class takes
+// precedence over Funcgo source, but Funcgo source takes precedence
+// over a .clj file, which may be stale output of the compiler.
+func funcgoResource(path String) {
+	if path->startsWith("/") {
+		base := subs(path, 1)
+		if !io.resource(base  str  "__init.class") {
+			io.resource(base  str  ".go")
+		}
+	}
+}
+
+// Return a version of the clojure.core/load function original that
+// compiles and loads Funcgo source files when it finds them.
+func funcgoLoad(original) {
+	func(paths...) {
+		for path := range paths {
+			if url := funcgoResource(path); url {
+				relative := subs(path, 1)  str  ".go"
+				core.LoadCompiled(relative, compiled(relative, slurp(url)), \*ns*\)
+			} else {
+				original(path)
+			}
+		}
+	}
+}
+
+// Return the var of the clojure.core/load function.
+func loadVar() {
+	findVar(symbol("clojure.core", "load"))
+}
+
+// Make require, use and load find Funcgo source files on the
+// classpath.  A namespace foo.bar is then loaded from foo/bar.go,
+// which is compiled in memory.  Calling this more than once has no
+// further effect.
+func Install() {
+	dosync(
+		if !*original {
+			refSet(original, varGet(loadVar()))
+			alterVarRoot(loadVar(), funcgoLoad)
+		}
+	)
+}
+
+// Undo Install, putting back the clojure.core/load function that it
+// replaced.  Calling this when the loader is not installed has no
+// effect.
+func Uninstall() {
+	dosync(
+		if *original {
+			alterVarRoot(loadVar(), constantly(*original))
+			refSet(original, nil)
+		}
+	)
+}
@@ -17,13 +17,20 @@
 package  main
 import (
         "clojure/java/io"
-        "clojure/pprint"
         "clojure/string"
         "clojure/tools/cli"
         "funcgo/core"
+        "funcgo/diagnostic"
+        "funcgo/emitter"
+        "funcgo/formatter"
+        "funcgo/manifest"
+        "funcgo/sourcemap"
 )
 import type (
-	java.io.{BufferedWriter, File, StringWriter, IOException}
+	clojure.lang.ExceptionInfo
+	java.io.{BufferedReader, BufferedWriter, File, StringWriter, IOException}
+	java.nio.file.{FileSystems, Path, StandardWatchEventKinds, WatchEvent, WatchKey, WatchService}
+	java.util.concurrent.{Callable, ExecutorService, Executors, Future}
 	jline.console.ConsoleReader
 )
 
@@ -33,54 +40,107 @@ commandLineOptions := [
         ["-n", "--nodes", "print out the parse tree that the parser produces"],
         ["-u", "--ugly",  "do not pretty-print the Clojure"],
         ["-f", "--force", "Force compiling even if not out-of-date"],
+        [nil, "--fmt", "rewrite Funcgo files in canonical style, instead of compiling"],
+        ["-l", "--list", "with --fmt, list the files whose style differs instead of rewriting them"],
+        ["-d", "--diff", "with --fmt, print the changes in style as diffs instead of rewriting the files"],
+        ["-c", "--clean", "delete the files compiled from Funcgo files that no longer exist, instead of compiling"],
+        ["-w", "--watch", "keep recompiling the directories given whenever their Funcgo files change"],
         ["-a", "--ambiguity",  "print out all matched parse trees to diagnose ambiguity"],
+        ["-D", "--diagnostics FORMAT", "print errors as text, or as json with one object per line",
+		DEFAULT, "text",
+		VALIDATE, [func{$1 == "text" || $1 == "json"}, "must be text or json"]],
+        ["-j", "--jobs N", "number of files to compile concurrently",
+		DEFAULT, 1,
+		PARSE_FN, func{Integer::parseInt($1)},
+		VALIDATE, [func{$1 > 0}, "must be a positive number"]],
+        ["-o", "--out-dir DIR", "write the Clojure output under DIR instead of beside the Funcgo files"],
+        [nil, "--cljs-out-dir DIR", "write the ClojureScript output under DIR (default is the --out-dir)"],
+        [nil, "--debug-dir DIR", "write files for debugging parse failures into DIR"],
         ["-h", "--help",  "print help"]
 ]
 
-// A version of pprint that preserves type hints.
-// See https://groups.google.com/forum/#!topic/clojure/5LRmPXutah8
-func prettyPrint(obj, writer) {
-	origDispatch := \pprint/*print-pprint-dispatch*\          // */ for emacs
-	pprint.withPprintDispatch(
-		func(o) {
-			if met := meta(o); met {
-				print("^")
-				if count(met) == 1 {
-					if met(TAG) {
-						origDispatch(met(TAG))
-					} else {
-						if met(PRIVATE) == true {
-							origDispatch(PRIVATE)
-						} else {
-							origDispatch(met)
-						}
+// The start of the first line of every file the compiler writes,
+// which is followed by the path of the Funcgo file relative to the
+// directory of the file written, and then by kRelativeSuffix.  Files
+// written by the 0.5.1 compiler have no suffix, as their path is the
+// one given on its command line.
+kCompiledHeader := ";; Compiled from "
+kRelativeSuffix := ", relative to this file"
+
+// Pretty-print the Clojure forms, each after the comments attached to
+// it, adding blank lines where needed so that each top-level form
+// starts on the same line as the Funcgo code it was compiled from.
+// The first line written is line number firstLine of the output.
+// Returns the source map positions of the top-level forms.
+func writePrettyTo(forms, writer BufferedWriter) {
+	writePrettyTo(forms, writer, 1)
+} (forms, writer BufferedWriter, firstLine) {
+	positions := loop(exprs = forms, line = firstLine, acc = []) {
+		if isEmpty(exprs) {
+			acc
+		} else {
+			expr       := first(exprs)
+			met        := meta(expr)
+			comments   := get(met, COMMENTS)
+			blankLines := max(0, get(met, LINE, line) - line - count(comments))
+			formLine   := line + blankLines + count(comments)
+			strWriter  := new StringWriter()
+			emitter.Pprint(expr, strWriter)
+			pretty     := strWriter->toString()
+			for _ := times blankLines {
+				writer->newLine()
+			}
+			for text := range comments {
+				writer->write(str(";;", text))
+				writer->newLine()
+			}
+			writer->write(pretty)
+			writer->newLine()
+			recur(
+				rest(exprs),
+				formLine + count(func{ $1 == '\n' }  filter  pretty) + 1,
+				if get(met, LINE) {
+					acc  conj  {
+						GEN_LINE:   formLine,
+						GEN_COLUMN: 1,
+						SRC_LINE:   met(LINE),
+						SRC_COLUMN: met(COLUMN)
 					}
 				} else {
-					origDispatch(met)
+					acc
 				}
-				print(" ")
-				pprint.pprintNewline(FILL)
-			}
-			origDispatch(o)
-		},
-		pprint.pprint(obj, writer)
-	)
+			)
+		}
+	}
+	writer->close()
+	positions
 }
 
-func writePrettyTo(cljText, writer BufferedWriter) {
-	for expr := range readString( str("[", cljText, "]")) {
-		prettyPrint(expr, writer)
-		writer->newLine()
+
+// Print a progress message, unless diagnostics are being printed as
+// JSON for other tools to read.
+func inform(opts, message...) {
+	if opts(DIAGNOSTICS) != "json" {
+		println(...message)
 	}
-	writer->close()
 }
 
+// Print the diagnostics in the format given by the options.
+func report(opts, diagnostics) {
+	for d := range diagnostics {
+		if opts(DIAGNOSTICS) == "json" {
+			println(diagnostic.Json(d))
+		} else {
+			println(diagnostic.Format(d))
+		}
+	}
+}
 
 func compileExpression(inPath, fgoText) {
-	cljText   := core.Parse(inPath, fgoText, EXPR)
+	forms     := core.Forms(inPath, fgoText, EXPR)
 	strWriter := new StringWriter()
 	writer    := new BufferedWriter(strWriter)
-	cljText  writePrettyTo  writer
+	forms  writePrettyTo  writer
 	strWriter->toString()
 }
 
@@ -96,11 +156,11 @@ func repl(){
 		fgoText := consoleReader->readLine()
 		if !string.isBlank(fgoText) {
 			try{
-				cljText := first(core.Parse("repl.go", fgoText, EXPR))
+				cljText := emitter.Emit(take(1, core.Forms("repl.go", fgoText, EXPR)))
 				println("Clojure: ", cljText)
 				println("Result:  ", eval(readString(cljText)))
 			} catch Exception e {
-				println(e)
+				report({}, diagnostic.Of("repl.go", e))
 			}
 			println()
 		}
@@ -111,87 +171,421 @@ func repl(){
 }
 
 func CompileString(inPath, fgoText) {
-	cljText   := core.Parse(inPath, fgoText)
+	forms     := core.Forms(inPath, fgoText, SOURCEFILE, false, false, false, true)
 	strWriter := new StringWriter()
 	writer    := new BufferedWriter(strWriter)
-	cljText  writePrettyTo  writer
+	forms  writePrettyTo  writer
 	strWriter->toString()
 }
 
-func compileFile(inFile File, root File, opts) {
+// Return the path of the Clojure file that the Funcgo file at path
+// compiles to.
+func outputPath(path) {
+	string.replace(path, /(\.[a-z]+)?\.go(s?)$/, ".clj$2$1")
+}
+
+// Return the root directory of the ClojureScript output, when there is
+// an output directory option.  Unless it is given, it is the output
+// directory.
+func cljsOutDir(opts) {
+	io.file(opts(CLJS_OUT_DIR) || opts(OUT_DIR))
+}
+
+// Return the path of file relative to the directory dir, with forward
+// slashes as in a URL.
+func relativePath(dir File, file File) {
+	dirPath  Path := dir->getCanonicalFile()->toPath()
+	filePath Path := file->getCanonicalFile()->toPath()
+	string.replace(str(dirPath->relativize(filePath)), File::separator, "/")
+}
+
+// Return the directory that the path of a Funcgo file given on the
+// command line is taken relative to: the working directory if the file
+// is under it, or otherwise the directory of the file.
+func argumentRoot(file File) {
+	here File := io.file(".")->getCanonicalFile()
+	if file->getCanonicalPath()->startsWith(here->getPath()  str  File::separator) {
+		here
+	} else {
+		file->getCanonicalFile()->getParentFile()
+	}
+}
+
+// Return a description of the file inFile in the source tree at root,
+// or nil if it is not a Funcgo file: the file itself, its path
+// relative to root, the file it compiles to, and the parser rule it
+// starts with.  The output is beside the input unless there is an
+// output directory option, in which case its path relative to the
+// output directory is that of the input relative to root.
+func sourceFile(inFile File, root File, opts) {
 	splitRoot := reMatches(/([^\.]+)(\.[a-z]+)?(\.gos?)/, inFile->getPath)
 	if !isNil(splitRoot) {
 		[_, inPath, suffixExtra, suffix] := splitRoot
-		compileFile(
-			inFile,
-			root,
-			inPath  str  suffix,
-			opts,
-			if isNil(suffixExtra) {""} else {suffixExtra}
-		)
-	}
-} (inFile File, root File, inPath, opts, suffixExtra) {
-	outFile := io.file(string.replace(inPath, /\.go(s?)$/, ".clj$1"  str  suffixExtra))
-	if opts(FORCE) || outFile->lastModified() < inFile->lastModified() {
-		prefixLen := root->getAbsolutePath()->length()
-		relative  := subs(inFile->getAbsolutePath(), prefixLen + 1)
-		println("  ", relative, "...")
+		relative := relativePath(root, inFile)
 		{
-			fgoText        := slurp(inFile)
-			lines          := count(func{ $1 == '\n' }  filter  fgoText)
-			start          := if suffixExtra == "" { SOURCEFILE } else { NONPKGFILE }
-			try {
-				beginTime := System::currentTimeMillis()
-				cljText String := core.Parse(
-					relative,
-					fgoText,
-					start,
-					opts(NODES), opts(SYNC), opts(AMBIGUITY)
+			IN_FILE:  inFile,
+			IN_PATH:  inPath  str  suffix,
+			RELATIVE: relative,
+			OUT_FILE: if outDir := opts(OUT_DIR); outDir {
+				io.file(if suffix == ".gos" { cljsOutDir(opts) } else { io.file(outDir) }, outputPath(relative))
+			} else {
+				io.file(outputPath(inFile->getPath()))
+			},
+			START:    if isNil(suffixExtra) { SOURCEFILE } else { NONPKGFILE }
+		}
+	}
+}
+
+// Return the Funcgo file named in the header of the Clojure file, or
+// nil if it has no such header, in which case it was not written by
+// the compiler.  A header written by the 0.5.1 compiler is taken to be
+// relative to the working directory.
+func compiledFrom(file File) {
+	reader BufferedReader := io.reader(file)
+	try {
+		line := str(reader->readLine())
+		if line->startsWith(kCompiledHeader) {
+			path := subs(line, count(kCompiledHeader))
+			if path->endsWith(kRelativeSuffix) {
+				io.file(
+					file->getAbsoluteFile()->getParentFile(),
+					subs(path, 0, count(path) - count(kRelativeSuffix))
 				)
-				duration := System::currentTimeMillis() - beginTime
-				// TODO(eob) open using with-open
-				writer         := io.writer(outFile)
-
-				writer->write(str(";; Compiled from ", inFile, "\n"))
-				if opts(UGLY) {
-					writer->write(cljText)
-					writer->close()
-				} else {
-					cljText  writePrettyTo  writer
-				}
-				if outFile->length() == 0 {
-					outFile->delete()
-					println("\t\tERROR: No output created.")
-				} else {
-					println("\t\t-->",
-						outFile->getPath(),
-						int(1000.0*lines/duration),
-						"lines/s")
-					if (outFile->length) / (inFile->length) < 0.4 {
-						println("WARNING: Output file is only",
-							int(100 * (outFile->length)
-								/ (inFile->length)),
-							"% the size of the input file")
-					}
+			} else {
+				io.file(path)
+			}
+		}
+	} finally {
+		reader->close()
+	}
+}
+
+// Return a writer to file, creating its directory if need be.  Throws
+// an exception with a diagnostic for the source at relative if file
+// exists and was not written by the compiler, so that hand-written
+// code is never overwritten.
+func outputWriter(relative, file File) {
+	if file->exists() && !compiledFrom(file) {
+		diagnostic.Throw(diagnostic.Error(
+			"E0201", relative, nil,
+			format("%s was not compiled from Funcgo, so it is not overwritten", file->getPath()),
+			"rename either it or the Funcgo file"
+		))
+	}
+	io.makeParents(file)
+	io.writer(file)
+}
+
+// Delete the files under the directories that were compiled from
+// Funcgo files that no longer exist, along with their source maps.
+// Files not written by the compiler are left alone.
+func clean(dirs, opts) {
+	for dir := range dirs {
+		for f := range fileSeq(dir) {
+			file File := f
+			if file->isFile() && reFind(/\.clj/, file->getName()) {
+				if source := compiledFrom(file); source && !source->exists() {
+					inform(opts, "  deleting", file->getPath())
+					file->delete()
+					io.file(file->getPath()  str  ".map")->delete()
 				}
-			} catch IOException e {
-				println("Parsing ", relative, " failed:\n", e->getMessage())
 			}
 		}
-  }
+	}
 }
 
-func compileTree(root File, opts) {
-	println(root->getName())
-	for f := range fileSeq(root) {
-		inFile File := f
+// Compile the source file described by source, which has the given
+// Funcgo text.  Returns the forms it compiled to, or nil if it could
+// not be compiled, in which case the errors have been reported.
+func compileSource(source, fgoText, opts) {
+	inFile  File := source(IN_FILE)
+	outFile File := source(OUT_FILE)
+	relative     := source(RELATIVE)
+	outDir  File := outFile->getAbsoluteFile()->getParentFile()
+	lines        := count(func{ $1 == '\n' }  filter  fgoText)
+	inform(opts, "  ", relative, "...")
+	try {
+		beginTime := System::currentTimeMillis()
+		forms := core.Forms(
+			relative,
+			fgoText,
+			source(START),
+			opts(NODES), opts(SYNC), opts(AMBIGUITY), true
+		)
+		duration := System::currentTimeMillis() - beginTime
+		// TODO(eob) open using with-open
+		writer         := outputWriter(relative, outFile)
+
+		writer->write(str(kCompiledHeader, relativePath(outDir, inFile), kRelativeSuffix, "\n"))
+		if opts(UGLY) {
+			writer->write(emitter.Emit(forms))
+			writer->close()
+		} else {
+			// line 1 of the output is the header
+			positions := writePrettyTo(forms, writer, 2)
+			if reFind(/\.gos$/, source(IN_PATH)) {
+				spit(
+					io.file(outFile->getPath()  str  ".map"),
+					sourcemap.Json(
+						outFile->getName(),
+						relativePath(outDir, inFile),
+						fgoText,
+						positions
+					)
+				)
+			}
+		}
+		if outFile->length() == 0 {
+			outFile->delete()
+			inform(opts, "\t\tERROR: No output created.")
+			nil
+		} else {
+			inform(opts, "\t\t-->",
+				outFile->getPath(),
+				int(1000.0*lines/duration),
+				"lines/s")
+			if (outFile->length) / (inFile->length) < 0.4 {
+				inform(opts, "WARNING: Output file is only",
+					int(100 * (outFile->length) /
+						(inFile->length)),
+					"% the size of the input file")
+			}
+			forms
+		}
+	} catch ExceptionInfo e {
+		report(opts, diagnostic.Of(relative, e))
+		nil
+	} catch IOException e {
+		report(opts, diagnostic.Of(relative, e))
+		nil
+	}
+}
+
+// Compile a single file, if it is a Funcgo file whose output is
+// out-of-date.
+func compileFile(inFile File, root File, opts) {
+	if source := sourceFile(inFile, root, opts); source {
+		outFile File := source(OUT_FILE)
+		if opts(FORCE) || outFile->lastModified() < inFile->lastModified() {
+			compileSource(source, slurp(inFile), opts)
+		}
+	}
+}
+
+// Compile the Funcgo source file of a tree described by source,
+// returning a pair of its relative path and its manifest entry, or nil
+// if it could not be compiled.
+func compileTreeSource(relative, source, opts) {
+	inFile File := source(IN_FILE)
+	try {
+		if forms := compileSource(source, slurp(inFile), opts); forms {
+			[relative, manifest.Entry(source(HASH), forms)]
+		}
+	} catch IOException e {
+		report(opts, diagnostic.Of(inFile->getPath(), e))
+	} catch Exception e {
+		e->printStackTrace()
+	}
+}
+
+// Return a pair of the result of calling f and what it printed.
+func withOutput(f) {
+	result := promise()
+	output := withOutStr(deliver(result, f()))
+	[*result, output]
+}
+
+// Submit the task to be called by a thread of the pool, returning its
+// future.
+func submit(pool ExecutorService, task Callable) {
+	pool->submit(task)
+}
+
+// Compile the given Funcgo source files of a tree, given as a map from
+// relative path to source description and content hash, returning the
+// manifest entries of the files that compiled.  With the jobs option,
+// that many files are compiled concurrently, and the output of each
+// file is printed in one piece, in the order the files were given.
+func compileSources(sources, opts) {
+	if opts(JOBS) > 1 && count(sources) > 1 {
+		pool ExecutorService := Executors::newFixedThreadPool(opts(JOBS))
 		try {
-			compileFile(inFile, root, opts)
-		} catch IOException e {
-			println(e->getMessage())
-		} catch Exception e {
-			e->printStackTrace()
+			futures := doall(for [relative, source] := lazy sources {
+				submit(pool, func() {
+					withOutput(func{compileTreeSource(relative, source, opts)})
+				})
+			})
+			into({}, for f := lazy futures {
+				future Future   := f
+				[entry, output] := future->get()
+				print(output)
+				flush()
+				entry
+			})
+		} finally {
+			pool->shutdown()
 		}
+	} else {
+		into({}, for [relative, source] := lazy sources {
+			compileTreeSource(relative, source, opts)
+		})
+	}
+}
+
+// Return the namespaces whose exported interface is different in the
+// entries compiled from what it was in the manifest before.
+func changedInterfaces(before, compiled) {
+	for [relative, entry] := lazy compiled if entry(EXPORTS) != get(before(relative), EXPORTS) {
+		entry(NAMESPACE)
+	}
+}
+
+// Compile the Funcgo files in the tree at root that need it: those
+// whose content has changed since the manifest of the tree was last
+// written, or whose output is missing, and then, following the import
+// graph, those that import a namespace whose exported interface has
+// changed.  Finally write the updated manifest.
+func compileTree(root File, opts) {
+	manifestFile := manifest.FileOf(root)
+	before       := manifest.Read(manifestFile)
+	sources      := into({}, for source := lazy keep(func{sourceFile($1, root, opts)}, fileSeq(root)) {
+		inFile File := source(IN_FILE)
+		[source(RELATIVE), source += {HASH: manifest.Hash(slurp(inFile))}]
+	})
+	isStale      := func(source) {
+		outFile File := source(OUT_FILE)
+		opts(FORCE) || !outFile->exists() || source(HASH) != get(before(source(RELATIVE)), HASH)
+	}
+	removed      := for [relative, entry] := lazy before if !sources(relative) { entry(NAMESPACE) }
+	kept         := selectKeys(before, keys(sources))
+	inform(opts, root->getName())
+	manifest.Write(manifestFile, loop(
+		pending = selectKeys(sources, concat(
+			for [relative, source] := lazy sources if isStale(source) { relative },
+			manifest.Dependents(kept, removed)
+		)),
+		current = kept,
+		done    = set{}
+	) {
+		if isEmpty(pending) {
+			current
+		} else {
+			compiled   := compileSources(pending, opts)
+			failed     := remove(compiled, keys(pending))
+			finished   := done  into  keys(pending)
+			changed    := changedInterfaces(current, compiled)
+			dependents := remove(finished, manifest.Dependents(current, changed))
+			recur(
+				selectKeys(sources, dependents),
+				apply(dissoc, current  merge  compiled, failed),
+				finished
+			)
+		}
+	})
+}
+
+// The changes to a directory that the watch option looks for.
+kWatchEvents := intoArray([
+	StandardWatchEventKinds::ENTRY_CREATE,
+	StandardWatchEventKinds::ENTRY_MODIFY,
+	StandardWatchEventKinds::ENTRY_DELETE
+])
+
+func isDirectory(file File) {
+	file->isDirectory()
+}
+
+// Register the directory dir and every directory below it with the
+// watcher, returning a map from each watch key to root, the tree it is
+// in.
+func registerTree(watcher WatchService, dir File, root File) {
+	into({}, for f := lazy fileSeq(dir) if isDirectory(f) {
+		path Path := f->toPath()
+		[path->register(watcher, kWatchEvents), root]
+	})
+}
+
+// Return whether the file at path is a Funcgo source file.
+func isFuncgo(path Path) {
+	boolean(reFind(/\.gos?$/, str(path->getFileName())))
+}
+
+// Compile the trees at roots, directories of Funcgo files, each time a
+// file in them is saved, until the process is stopped or the thread is
+// interrupted.  As for a
+// normal run, only the files that changed and the files that depend on
+// them are compiled.
+func watch(roots, opts) {
+	watcher WatchService := FileSystems::getDefault()->newWatchService()
+	inform(opts, "Watching", ", "  string.join  roots, "for changes...")
+	try {
+		loop(watched = apply(merge, for root := lazy roots { registerTree(watcher, root, root) })) {
+			key     WatchKey := watcher->take()
+			dir     Path     := key->watchable()
+			root             := watched(key)
+			paths            := doall(for e := lazy key->pollEvents() {
+				event WatchEvent := e
+				dir->resolve(cast(Path, event->context()))
+			})
+			created          := doall(for path := lazy paths if isDirectory(path->toFile()) {
+				registerTree(watcher, path->toFile(), root)
+			})
+			isValid          := key->reset()
+			if some(isFuncgo, paths) {
+				compileTree(root, opts)
+			}
+			recur(apply(merge, if isValid { watched } else { dissoc(watched, key) }, created))
+		}
+	} finally {
+		watcher->close()
+	}
+}
+
+// Rewrite the Funcgo file in canonical style, or with the list or diff
+// options, print its name or the changes if its style differs.
+func formatFile(file File, opts) {
+	path   := file->getPath()
+	source := slurp(file)
+	try {
+		if formatted := formatter.Format(path, source); formatted != source {
+			if opts(LIST) {
+				println(path)
+			}
+			if opts(DIFF) {
+				print(formatter.Diff(path, source, formatted))
+			}
+			if !opts(LIST) && !opts(DIFF) {
+				spit(file, formatted)
+			}
+		}
+	} catch ExceptionInfo e {
+		report(opts, diagnostic.Of(path, e))
+	}
+}
+
+// Format the Funcgo files given, or in the directories given.
+func formatAll(paths, opts) {
+	for path := range paths {
+		for f := range fileSeq(io.file(path)) {
+			file File := f
+			if file->isFile() && reFind(/\.gos?$/, file->getName()) {
+				formatFile(file, opts)
+			}
+		}
+	}
+}
+
+// Return the message for arguments that cannot be used together, or
+// nil if there is no such problem.
+func usageProblem(otherArgs, opts) {
+	switch {
+	case not(seq(otherArgs)):
+		"Missing directory or file argument."
+	case opts(WATCH) && (opts(FMT) || opts(CLEAN)):
+		"The --watch option cannot be used with --fmt or --clean."
+	case opts(WATCH) && !isEvery(func{isDirectory(io.file($1))}, otherArgs):
+		format("The --watch option only watches directories, which %s is not.",
+			first(remove(func{isDirectory(io.file($1))}, otherArgs)))
 	}
 }
 
@@ -211,27 +605,46 @@ func Compile(args...) {
 	cmdLine   := args  cli.parseOpts  commandLineOptions
 	otherArgs := cmdLine(ARGUMENTS)
 	opts      := cmdLine(OPTIONS)
-	here      := io.file(".")
 
+	if dir := opts(DEBUG_DIR); dir {
+		System::setProperty("funcgo.debug.dir", dir)
+	}
 	if cmdLine(ERRORS) || opts(HELP){
 		println(cmdLine(SUMMARY))
 	}else{
-		if not(seq(otherArgs)) {
-			println("Missing directory or file argument.")
+		if problem := usageProblem(otherArgs, opts); problem {
+			println(problem)
 			printError(cmdLine)
 		} else {
-			// file arguments
-			for arg := range otherArgs {
-				if file := io.file(arg); file->isDirectory {
-					compileTree(file, opts)
-				} else {
-					try {
-						compileFile(file, here, opts)
-					} catch Exception e {
-						println("\n", e->getMessage())
+			switch {
+			case opts(FMT):
+				formatAll(otherArgs, opts)
+			case opts(CLEAN):
+				clean(
+					if outDir := opts(OUT_DIR); outDir {
+						distinct([io.file(outDir), cljsOutDir(opts)])
+					} else {
+						map(io.file, otherArgs)
+					},
+					opts
+				)
+			default:
+				// file arguments
+				for arg := range otherArgs {
+					if file := io.file(arg); file->isDirectory {
+						compileTree(file, opts)
+					} else {
+						try {
+							compileFile(file, argumentRoot(file), opts)
+						} catch Exception e {
+							report(opts, diagnostic.Of(arg, e))
+						}
 					}
 				}
 			}
+			if opts(WATCH) {
+				watch(map(io.file, otherArgs), opts)
+			}
 		}
 		if opts(REPL) {
 			repl()
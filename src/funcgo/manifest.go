@@ -0,0 +1,132 @@
+//////
+// This file is part of the Funcgo compiler.
+//
+// Copyright (c) 2014 Eamonn O'Brien-Strain All rights
+// reserved. This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License v1.0 which
+// accompanies this distribution, and is available at
+// http://www.eclipse.org/legal/epl-v10.html
+//
+// Contributors:
+// Eamonn O'Brien-Strain e@obrain.com - initial author
+//////
+
+// A manifest records what the compiler learned about each file of a
+// source tree the last time it compiled it: a hash of its content, its
+// namespace, the namespaces it imports and the public interface it
+// exports.  The imports make up the import graph of the tree, which
+// the compiler uses to recompile only the files that have changed and
+// the files that depend on an interface that has changed.
+
+package manifest
+import (
+	"clojure/edn"
+	"clojure/java/io"
+	"clojure/string"
+)
+import type (
+	java.io.File
+	java.security.MessageDigest
+)
+
+// The name of the manifest file in the root of a source tree.
+kFileName := ".fgoc-manifest.edn"
+
+// The kinds of ns clause that import namespaces.
+kImportClauses := set{REQUIRE, REQUIRE_MACROS, USE}
+
+// Return the hex SHA-1 hash of the string s.
+func Hash(s String) {
+	digest := MessageDigest::getInstance("SHA-1")->digest(s->getBytes("UTF-8"))
+	apply(str, for b := lazy digest { format("%02x", b & 0xff) })
+}
+
+// Return the manifest file of the source tree at root.
+func FileOf(root File) {
+	io.file(root, kFileName)
+}
+
+// Return the manifest read from file, which is empty if there is no
+// such file or it cannot be read.
+func Read(file File) {
+	if file->exists() {
+		try {
+			edn.readString(slurp(file))
+		} catch Exception e {
+			{}
+		}
+	} else {
+		{}
+	}
+}
+
+// Write the manifest to file, one entry per line, sorted by path so
+// that the file changes little from one compile to the next.
+func Write(file File, manifest) {
+	entries := for [path, entry] := lazy sort(manifest) { prStr(path)  str  " "  str  prStr(entry) }
+	spit(file, str("{", "\n "  string.join  entries, "}\n"))
+}
+
+// Return the names of the namespaces imported by the ns form.
+func imports(nsForm) {
+	clauses := filter(func{ isSeq($1) && kImportClauses(first($1)) }, rest(nsForm))
+	for spec := lazy mapcat(rest, clauses) {
+		str(if isVector(spec) { first(spec) } else { spec })
+	}
+}
+
+// Return the argument vectors of the body of a function definition,
+// which may have a doc string and an attribute map before either one
+// argument vector or one list per arity.
+func Arglists(body) {
+	specs := dropWhile(func{ isString($1) || isMap($1) }, body)
+	if isVector(first(specs)) {
+		[first(specs)]
+	} else {
+		for arity := lazy specs if isSeq(arity) { first(arity) }
+	}
+}
+
+// Return a description of what the top-level form exports, or nil if
+// it is not a public definition.  The description includes the
+// argument vectors of functions and macros and the fields of records
+// and types, so that it changes whenever code that uses the
+// definition may need to be compiled differently.
+func exported(form) {
+	if isSeq(form) && isSymbol(first(form)) && isSymbol(second(form)) {
+		head := name(first(form))
+		sym  := second(form)
+		if head->startsWith("def") && !head->endsWith("-") && !get(meta(sym), PRIVATE) {
+			" "  string.join  concat([head, sym], switch head {
+			case "defn", "defmacro":
+				map(prStr, Arglists(drop(2, form)))
+			case "defrecord", "deftype":
+				[prStr(nth(form, 2))]
+			default:
+				[]
+			})
+		}
+	}
+}
+
+// Return the manifest entry of a file with content of the given hash
+// that compiled to the forms.
+func Entry(hash, forms) {
+	nsForm := first(forms)
+	isNs   := isSeq(nsForm) && first(nsForm) == symbol("ns")
+	{
+		HASH:      hash,
+		NAMESPACE: if isNs { str(second(nsForm)) },
+		IMPORTS:   if isNs { vec(sort(set(imports(nsForm)))) } else { [] },
+		EXPORTS:   vec(sort(keep(exported, forms)))
+	}
+}
+
+// Return the paths of the files in the manifest that import any of the
+// given namespaces.
+func Dependents(manifest, namespaces) {
+	imported := set(namespaces)
+	for [path, entry] := lazy manifest if some(imported, entry(IMPORTS)) {
+		path
+	}
+}
@@ -0,0 +1,122 @@
+//////
+// This file is part of the Funcgo compiler.
+//
+// Copyright (c) 2014 Eamonn O'Brien-Strain All rights
+// reserved. This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License v1.0 which
+// accompanies this distribution, and is available at
+// http://www.eclipse.org/legal/epl-v10.html
+//
+// Contributors:
+// Eamonn O'Brien-Strain e@obrain.com - initial author
+//////
+
+// Deciding which newlines end statements, following the semicolon
+// insertion rule of Go.  The newlines that do not end a statement are
+// turned into space before parsing, so that a long expression can be
+// continued on the next line after an operator, a comma, an opening
+// bracket or a -> without making the parse ambiguous.
+
+package newlines
+import type (
+	java.util.regex.Matcher
+)
+
+// The keywords after which a newline does not end a statement, even
+// though they look like identifiers.
+kKeywords := set{
+	"case", "chan", "const", "default", "else", "for", "func", "go", "if",
+	"import", "interface", "package", "select", "struct", "switch", "type", "var"
+}
+
+// The keywords that continue the statement of the block before them,
+// so that a newline between the block and them never ends the
+// statement, as the compiler has always accepted.
+kFollowers := set{"catch", "else", "finally"}
+
+// Matches the lexeme at the start of the region, which is, in the
+// order of the groups: space, a newline, a comment, a literal other
+// than a regular expression, an identifier, a closing bracket, or any
+// other character.
+kLexeme := /([ \t\r\f]+)|(\n)|(\/\/[^\n]*|\/\*[\s\S]*?\*\/)|(\x60[^\x60]*\x60|\\\x60[^\x60]*\x60|["“”](?:[^"\\]|\\.)*["“”]|'(?:\\u[0-9a-fA-F]{4}|\\[0-7]{3}|\\.|[^\n])'|\\[^\n\\]+\\|\$[1-9*]|\d[\w.]*)|([\p{L}_[\p{S}&&[^\p{Punct}]]][\p{L}_[\p{S}&&[^\p{Punct}]]\p{Nd}#]*)|([)\]}])|([\s\S])/
+
+// Matches the space and the token at the start of the region when the
+// token continues an expression and cannot start a statement.
+kContinuation := /[ \t\r\f]*(?:[,.:;=|&>\/%]|->)/
+
+// Matches a regular expression literal, which can only start where an
+// operand can, since otherwise its slash is a division.
+kRegex := /\/(?!\*)(?:[^\/\n\\]|\\.)+\//
+
+// Scan text, returning the SPACES, the indexes of the newlines that do
+// not end a statement, and the SPANS of the comments and literals
+// other than regular expressions.  As in Go, a newline ends a
+// statement if the last token before it is an identifier other than a
+// keyword, a literal or a closing bracket, and a block comment with a
+// newline in it counts as a newline, though only if what follows it
+// could start a statement.  Unlike in Go, a newline before a closing
+// bracket, one of kFollowers or the end of the text never ends a
+// statement, so that a multi-line list needs no comma after its last
+// element and an else can start a line.
+func scan(text String) {
+	lexeme       Matcher := reMatcher(kLexeme, text)
+	regex        Matcher := reMatcher(kRegex, text)
+	continuation Matcher := reMatcher(kContinuation, text)
+	loop(i = 0, isOperand = false, ending = nil, spaces = [], spans = []) {
+		switch {
+		case i >= count(text):
+			{SPACES: spaces  into  ending, SPANS: spans}
+		case (!isOperand || ending) && regex->region(i, count(text))->lookingAt():
+			recur(regex->end(), true, nil, spaces, spans)
+		default: {
+			end      := if lexeme->region(i, count(text))->lookingAt() { lexeme->end() }
+			newlines := vec(for j := lazy range(i, end) if text[j] == '\n' { j })
+			newSpans := if lexeme->group(3) || lexeme->group(4) { spans  conj  [i, end] } else { spans }
+			switch {
+			case lexeme->group(1) || isEmpty(newlines) && lexeme->group(3):
+				recur(end, isOperand, ending, spaces, newSpans)
+			case lexeme->group(3) && isOperand && isNil(ending) &&
+				continuation->region(end, count(text))->lookingAt():
+				recur(end, true, nil, spaces  into  newlines, newSpans)
+			case lexeme->group(2) || lexeme->group(3):
+				if isOperand && isNil(ending) {
+					recur(end, true, newlines, spaces, newSpans)
+				} else {
+					recur(end, isOperand, ending, spaces  into  newlines, newSpans)
+				}
+			default:
+				recur(
+					end,
+					lexeme->group(4) || lexeme->group(6) ||
+						lexeme->group(5) && !(kKeywords  isContains  lexeme->group(5)),
+					nil,
+					if lexeme->group(6) || (kFollowers  isContains  lexeme->group(5)) {
+						spaces  into  ending
+					} else {
+						spaces
+					},
+					newSpans
+				)
+			}
+		}
+		}
+	}
+}
+
+// Return the spans of the comments and of the literals other than
+// regular expressions in text, inside which a line can start with
+// something that is not code.
+func OpaqueSpans(text String) {
+	scan(text)(SPANS)
+}
+
+// Return text with the newlines that do not end a statement replaced
+// by form feeds, which the parser takes as space.  Every other
+// character stays at the same index.
+func Blanked(text String) {
+	builder := new StringBuilder(text)
+	for i := range scan(text)(SPACES) {
+		builder->setCharAt(i, '\u000c')
+	}
+	builder->toString()
+}
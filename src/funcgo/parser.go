@@ -16,7 +16,7 @@ package parser
 import insta "instaparse/core"
 
 whitespaceOrComments := insta.parser(`
-    ws-or-comments = #'(\s|(//[^\n]*\n))+'
+    ws-or-comments = #'([ \t\r\f]|(//[^\n]*)|(/\*(?:(?!\*/)[^\n])*\*/))+'
 `, NO_SLURP, true,  // for App Engine compatibility
 );
 
@@ -25,24 +25,25 @@ sourcefile = packageclause (expressions|topwithconst|topwithassign)
 nonpkgfile = (expressions|topwithconst|topwithassign) <NL>?
  packageclause = <#'\bpackage\b'> pkg <NL> importdecls
    pkg =  Identifier {<'/'> Identifier}
-   <NL> = #'\s*[;\n]\s*' | #'\s*//[^\n]*\n\s*'
-   importdecls = {AnyImportDecl}
+   <NL> = #'\s*[;\n]\s*'
+        | #'\s*/\*(?:(?!\*/)[^\n])*\n(?s:.*?)\*/\s*'   (* a block comment with a newline in it *)
+   importdecls = {AnyImportDecl <NL>?}
      <AnyImportDecl> = importdecl | macroimportdecl | externimportdecl | typeimportdecl | exclude
      exclude = <#'\bexclude\b' '('>
                   (Identifier|operator) { <','> (Identifier|operator) } <')'>
      importdecl = <#'\bimport\b' '('>
-                    ImportSpec {ImportSpec}
+                    ImportSpec {<NL>? ImportSpec}
                   <')'>
                 | <#'\bimport\b'>  ImportSpec
      macroimportdecl = <#'\bimport\b' #'\bmacros\b' '('>
-                         ImportSpec {ImportSpec} <')'>
+                         ImportSpec {<NL>? ImportSpec} <')'>
                      | <#'\bimport\b' #'\bmacros\b'> ImportSpec
      <externimportdecl> = <#'\bimport\b' #'\bextern\b' '('>
-                              externimportspec {externimportspec} <')'>
+                              externimportspec {<NL>? externimportspec} <')'>
                      | <#'\bimport\b' #'\bextern\b'> externimportspec
        externimportspec = identifier
      typeimportdecl = <#'\bimport\b' #'\btype\b' '('>
-                        typeimportspec {typeimportspec} <')'>
+                        typeimportspec {<NL>? typeimportspec} <')'>
                      | <#'\bimport\b' #'\btype\b'> typeimportspec
        typeimportspec = typepackageimportspec <'.'> (
                                           JavaIdentifier
@@ -55,11 +56,11 @@ nonpkgfile = (expressions|topwithconst|topwithassign) <NL>?
               | expressions <NL> expr
    <expr>  = precedence00 | Vars | (*shortvardecl |*) ifelseexpr | letifelseexpr | tryexpr | forrange |
                    forlazy | fortimes | forcstyle | Blocky | ExprSwitchStmt
-                     | functiondecl
+                     | functiondecl | methoddecl
 
 
      <Blocky> = block | withconst | withassign | loop
-       withconst  = <'{' #'\bconst\b'> ( const <NL> | <'('> consts <')'> ) expressions <'}'>
+       withconst  = <'{' #'\bconst\b'> ( const <NL> | <'('> consts <')'> <NL>? ) expressions <'}'>
        withassign = <'{'> assigns <NL> expressions <'}'>
          consts  = ( const {<NL> const} )?
          assigns = assign {<NL> assign}
@@ -82,25 +83,29 @@ nonpkgfile = (expressions|topwithconst|topwithassign) <NL>?
          commaconsts = ( const { <','> const} )?
        <ImpliedDo> =  <'{'> expressions <'}'> | withconst | withassign
        block = <'{'> expr {<NL> expr} <'}'>
-       topwithconst  =  <#'\bconst\b'> ( const | <'('> consts <')'> )  expressions
+       topwithconst  =  <#'\bconst\b'> ( const <NL> | <'('> consts <')'> <NL>? )  expressions
        topwithassign =  assigns <NL> expressions
-     <ExprSwitchStmt> = boolswitch | constswitch | letconstswitch | typeswitch
+     <ExprSwitchStmt> = boolswitch | constswitch | letconstswitch | typeswitch | lettypeswitch
                         | selectstmtingo | selectstmt
-       selectstmt = <#'\bselect\b' '{'> (CommClause {<NL> CommClause})? <'}'>
+       selectstmt = <#'\bselect\b' '{'> (CommClause {<NL>? CommClause})? <'}'>
          <CommClause> = sendclause | recvclause | recvvalclause | defaultclause
            sendclause       = <#'\bcase\b'> UnaryExpr        <    '<-'> UnaryExpr <':'> expressions?
            recvclause       = <#'\bcase\b'                   '<-'> expr <':'> expressions?
            recvvalclause    = <#'\bcase\b'>  identifier <'=' '<-'> expr <':'> expressions
            defaultclause    = <#'\bdefault\b'                            ':'> expressions?
-       selectstmtingo = <#'\bselect\b' '{'> CommClauseInGo {<NL> CommClauseInGo} <'}'>
+       selectstmtingo = <#'\bselect\b' '{'> CommClauseInGo {<NL>? CommClauseInGo} <'}'>
          <CommClauseInGo> = sendclauseingo | recvclauseingo | recvvalclauseingo | defaultclause
            sendclauseingo    = <#'\bcase\b'> UnaryExpr        <    '<:'> UnaryExpr <':'> expressions?
            recvclauseingo    = <#'\bcase\b'                   '<:'> expr <':'> expressions?
            recvvalclauseingo = <#'\bcase\b'>  identifier <'=' '<:'> expr <':'> expressions
-       typeswitch = <#'\bswitch\b'> PrimaryExpr <'.' '(' #'\btype\b' ')' '{'
-                           #'\bcase\b'> typename <':'> expressions
-                         {<NL #'\bcase\b'> typename <':'> expressions}
-                         (<NL #'\bdefault\b'          ':'> expressions )? <'}'>
+       typeswitch = <#'\bswitch\b'> PrimaryExpr TypeClauses
+       lettypeswitch = <#'\bswitch\b'> Identifier <':='> PrimaryExpr TypeClauses
+         <TypeClauses> = <'.' '(' #'\btype\b' ')' '{'> typecaseclause {<NL> typecaseclause}
+                           (<NL> typedefaultclause)? <'}'>
+           typecaseclause = <#'\bcase\b'> TypeCase {<','> TypeCase} <':'> expressions
+             <TypeCase> = nilcase | !nilcase typename
+               nilcase = <#'\bnil\b'>
+           typedefaultclause = <#'\bdefault\b' ':'> expressions
        boolswitch = <#'\bswitch\b' '{'> boolcaseclause {<NL> boolcaseclause} <'}'>
        constswitch = <#'\bswitch\b'> expr <'{'> constcaseclause {<NL> constcaseclause} <'}'>
        letconstswitch = <#'\bswitch\b'> Destruct <':='> expr <NL>
@@ -131,7 +136,7 @@ nonpkgfile = (expressions|topwithconst|topwithassign) <NL>?
          sendopingo = <'<:'>
      precedence0 = precedence1
                  | precedence0 <DoubleSpace> symbol <DoubleSpace>precedence1
-       DoubleSpace = <#'[ \t][ \t]'>
+       DoubleSpace = <#'[ \t][ \t]|\t'>
        symbol = Identifier
               | Identifier <'.'>  Identifier
               | Identifier <'.'>  operator
@@ -154,7 +159,7 @@ nonpkgfile = (expressions|topwithconst|topwithassign) <NL>?
                  bitxor = <'^'>
 	       precedence5 = UnaryExpr
                            | precedence5 mulop UnaryExpr
-	         mulop = '*' | (!'//' '/') | mod | shiftleft | shiftright | bitand | bitandnot
+	         mulop = '*' | (!'//' !'/*' '/') | mod | shiftleft | shiftright | bitand | bitandnot
                    shiftleft = <'<<'>
                    shiftright = <'>>'>
                    mod = <'%'>
@@ -178,7 +183,7 @@ nonpkgfile = (expressions|topwithconst|topwithassign) <NL>?
 	     isidentifier = <#'\bis'> #'\p{L}' identifier         (* TODO(eob) make a regex *)
 	     mutidentifier = <#'\bmutate'> #'\p{L}' identifier    (* TODO(eob) make a regex *)
 	     escapedidentifier = #'\\[^\n\\]+\\'
-     <Vars> = <#'\bvar\b'> ( <'('> VarDecl+ <')'> | VarDecl )
+     <Vars> = <#'\bvar\b'> ( <'('> VarDecl {<NL>? VarDecl} <')'> | VarDecl )
      <VarDecl> = primarrayvardecl | arrayvardecl | vardecl1 | vardecl2
        primarrayvardecl = Identifier <'['> int_lit  <']'> primitivetype
        arrayvardecl = Identifier <'['> int_lit  <']'> typename
@@ -252,20 +257,20 @@ nonpkgfile = (expressions|topwithconst|topwithassign) <NL>?
            <Call> =  <'('> ArgumentList? <')'>
              <ArgumentList> = expressionlist                                      (* [ Ellipsis ] *)
                expressionlist = expr { <','> expr} ( <','>)?
-         <TypeDecl> = <#'\btype\b'> ( TypeSpec | <'('> {TypeSpec} <')'> )
+         <TypeDecl> = <#'\btype\b'> ( TypeSpec | <'('> ( TypeSpec {<NL>? TypeSpec} )? <')'> )
 	   <TypeSpec> = interfacespec | structspec
              structspec = JavaIdentifier <#'\bstruct\b' '{'> (fields )? <'}'>
                fields = Field
                         | fields <NL> Field
                  <Field> = Identifier | typedidentifiers
-	     interfacespec = JavaIdentifier <#'\binterface\b' '{'> {MethodSpec} <'}'>
+	     interfacespec = JavaIdentifier <#'\binterface\b' '{'> ( MethodSpec {<NL>? MethodSpec} )? <'}'>
 	       <MethodSpec> = voidmethodspec | typedmethodspec
 	       voidmethodspec = Identifier <'('> methodparameters? <')'>
 	       typedmethodspec = Identifier <'('> methodparameters? <')'> typename
 		 methodparameters = methodparam
 				  | methodparameters <','> methodparam
 		   methodparam = symbol ( Identifier)?
-         implements = <#'\bimplements\b'> typename <#'\bfunc\b' '('> JavaIdentifier <')'> (
+         implements = <#'\bimplements\b'> typename <NL>? <#'\bfunc\b' '('> JavaIdentifier <')'> (
                           MethodImpl | <'('>  MethodImpl {<NL> MethodImpl}   <')'>
                         )
            <MethodImpl> = typedmethodimpl | untypedmethodimpl
@@ -274,6 +279,8 @@ nonpkgfile = (expressions|topwithconst|topwithassign) <NL>?
              typedmethodimpl = Identifier <'('>  parameters? <')'> typename
                                    (ReturnBlock|Blocky)
          functiondecl = <#'\bfunc\b'> (Identifier|operator) Function
+         methoddecl = <#'\bfunc\b' '('> receiver <')'> JavaIdentifier Function
+           receiver = ( Identifier )? ( <'*'> )? typename
          funclikedecl = <#'\bfunc\b' '<'> symbol <'>'> Identifier Function
            <Function> = FunctionPart | functionparts
              functionparts = FunctionPart FunctionPart { FunctionPart}
@@ -289,7 +296,7 @@ nonpkgfile = (expressions|topwithconst|topwithassign) <NL>?
          <Operand> = Literal | OperandName | label | islabel | new  | <'('> expr <')'> (*|MethodExpr*)
            label = #'\b\p{Lu}[\p{Lu}_\p{Nd}#\.]*\b'
 	   islabel = <#'\bIS_'> #'\p{Lu}[\p{Lu}_\p{Nd}#\.]*\b'
-           <Literal> = BasicLit | veclit | dictlit | setlit | structlit | functionlit | shortfunctionlit
+           <Literal> = BasicLit | veclit | dictlit | setlit | structlit | keyedstructlit | functionlit | shortfunctionlit
              functionlit = <#'\bfunc\b'> Function
              shortfunctionlit = <#'\bfunc\b' '{'> expr <'}'>
              <BasicLit> = int_lit | bigintlit | regex | string_lit | rune_lit | floatlit | bigfloatlit (*| imaginary_lit *)
@@ -300,12 +307,12 @@ nonpkgfile = (expressions|topwithconst|topwithassign) <NL>?
                <FloatLitB> = #'[0-9]+[eE][+-]?[0-9]+'
 
                bigfloatlit = (floatlit | int_lit) #'M\b'
-               <int_lit> = decimallit | octal_lit | hexlit
+               <int_lit> = decimallit | octallit | hexlit
 		 decimallit = #'[1-9][0-9]*' | #'[0-9]'
-		 <octal_lit>  = #'0[0-7]+'
+		 octallit  = #'0[0-7]+'
 		 hexlit    = <'0x'> #'[0-9a-fA-F]+'
                bigintlit = int_lit #'N\b'
-               regex = #'/([^\/\n\\]|\\.)+/'
+               regex = #'/(?!\*)([^\/\n\\]|\\.)+/'
 	       <string_lit> = interpretedstringlit | rawstringlit | clojureescape
                  interpretedstringlit = #'["“”](?:[^"\\]|\\.)*["“”]'
                  rawstringlit = <#'\x60'> #'[^\x60]*' <#'\x60'>     (* \x60 is back quote character *)
@@ -334,6 +341,8 @@ nonpkgfile = (expressions|topwithconst|topwithassign) <NL>?
                dictelement = expr <':'> expr
              NotType = #'\bfunc\b' | #'\bset\b' | prefix
              structlit = !NotType typename <'{'> ( expr {<','> expr} )? (<','> )? <'}'>
+             keyedstructlit = !NotType typename <'{'> keyedelement {<','> keyedelement} (<','> )? <'}'>
+               keyedelement = Identifier <':'> expr
              setlit = <#'\bset\b' '{'> ( expr {<','> expr} )? <'}'>
            new = <#'\bnew\b'> typename
            <OperandName> = symbol | NonAlphaSymbol                           (*| QualifiedIdent*)
@@ -0,0 +1,130 @@
+//////
+// This file is part of the Funcgo compiler.
+//
+// Copyright (c) 2014 Eamonn O'Brien-Strain All rights
+// reserved. This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License v1.0 which
+// accompanies this distribution, and is available at
+// http://www.eclipse.org/legal/epl-v10.html
+//
+// Contributors:
+// Eamonn O'Brien-Strain e@obrain.com - initial author
+//////
+
+// Version 3 source maps, mapping positions in generated ClojureScript
+// back to the Funcgo source it was compiled from.  Positions are
+// {GEN_LINE, GEN_COLUMN, SRC_LINE, SRC_COLUMN} dicts, all one-based.
+// See https://sourcemaps.info/spec.html
+
+package sourcemap
+import (
+	s "clojure/string"
+	"funcgo/json"
+)
+
+kBase64 := "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+kBase64Index := zipmap(kBase64, range(64))
+
+// Base64 VLQ encoding of an integer.
+func vlq(n) {
+	loop(v = if n < 0 { (-n << 1) + 1 } else { n << 1 }, acc = "") {
+		digit := v & 31
+		more  := v >> 5
+		if more == 0 {
+			acc  str  kBase64[digit]
+		} else {
+			recur(more, acc  str  kBase64[digit | 32])
+		}
+	}
+}
+
+// Decode a string of Base64 VLQ encoded integers.
+func unvlq(segment) {
+	loop(chars = seq(segment), value = 0, shift = 0, acc = []) {
+		if isEmpty(chars) {
+			acc
+		} else {
+			digit := kBase64Index(first(chars))
+			v     := value + ((digit & 31) << shift)
+			if (digit & 32) == 0 {
+				n := if (v & 1) == 1 { -(v >> 1) } else { v >> 1 }
+				recur(rest(chars), 0, 0, acc  conj  n)
+			} else {
+				recur(rest(chars), v, shift + 5, acc)
+			}
+		}
+	}
+}
+
+// Return the encoded segments of one generated line, and the source
+// position of its last segment, which the next line is relative to.
+func encodeLine(positions, srcLine0, srcColumn0) {
+	loop(remaining = positions, genColumn = 0, srcLine = srcLine0, srcColumn = srcColumn0, acc = []) {
+		if isEmpty(remaining) {
+			[","  s.join  acc, srcLine, srcColumn]
+		} else {
+			{g: GEN_COLUMN, l: SRC_LINE, c: SRC_COLUMN} := first(remaining)
+			segment := str(
+				vlq(g - 1 - genColumn),
+				vlq(0),
+				vlq(l - 1 - srcLine),
+				vlq(c - 1 - srcColumn)
+			)
+			recur(rest(remaining), g - 1, l - 1, c - 1, acc  conj  segment)
+		}
+	}
+}
+
+// Return the "mappings" field of a source map of the given positions,
+// which must be in the order they appear in the generated code.
+func Mappings(positions) {
+	byLine   := GEN_LINE  groupBy  positions
+	lastLine := max  reduce  (0  cons  keys(byLine))
+	loop(line = 1, srcLine = 0, srcColumn = 0, acc = []) {
+		if line > lastLine {
+			";"  s.join  acc
+		} else {
+			[encoded, nextSrcLine, nextSrcColumn] := encodeLine(byLine(line), srcLine, srcColumn)
+			recur(line + 1, nextSrcLine, nextSrcColumn, acc  conj  encoded)
+		}
+	}
+}
+
+// Return the positions encoded in the "mappings" field of a source map.
+func Decode(mappings String) {
+	lines := s.split(mappings, /;/, -1)
+	loop(line = 1, remaining = lines, srcLine = 0, srcColumn = 0, acc = []) {
+		if isEmpty(remaining) {
+			acc
+		} else {
+			segments  := for seg := lazy s.split(first(remaining), /,/) if notEmpty(seg) {
+				unvlq(seg)
+			}
+			decoded   := rest(reductions(
+				func([_, genColumn, prevSrcLine, prevSrcColumn], [dGen, _, dLine, dColumn]) {
+					[line, genColumn + dGen, prevSrcLine + dLine, prevSrcColumn + dColumn]
+				},
+				[line, 0, srcLine, srcColumn],
+				segments
+			))
+			[_, _, lastSrcLine, lastSrcColumn] := last([line, 0, srcLine, srcColumn]  cons  decoded)
+			positions := for [l, g, sl, sc] := lazy decoded {
+				{GEN_LINE: l, GEN_COLUMN: g + 1, SRC_LINE: sl + 1, SRC_COLUMN: sc + 1}
+			}
+			recur(line + 1, rest(remaining), lastSrcLine, lastSrcColumn, acc  into  positions)
+		}
+	}
+}
+
+// Return the JSON text of a source map for the generated file genName
+// compiled from the Funcgo file srcName whose contents are srcText.
+func Json(genName, srcName, srcText, positions) {
+	json.Write({
+		"version":        3,
+		"file":           genName,
+		"sources":        [srcName],
+		"sourcesContent": [srcText],
+		"names":          [],
+		"mappings":       Mappings(positions)
+	})
+}
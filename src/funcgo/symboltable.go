@@ -12,12 +12,15 @@
 //////
 
 // A symbol table is a mutable state that keeps track of the symbols
-// declared, so that the codegenerator can throw exceptions when it
-// encounters an undefined symbol.
+// declared, so that the codegenerator can report errors when it
+// encounters an undefined symbol.  It also collects the diagnostics
+// of all the errors found, so that they can be reported together.
 
 package symboltable
-import "clojure/string"
-import type java.io.IOException
+import (
+	"clojure/string"
+	"funcgo/diagnostic"
+)
 
 // Return a new symbol table.
 func New() {
@@ -26,16 +29,27 @@ func New() {
 		"double": TYPE,
 		"boolean": TYPE,
 		UNUSED_PACKAGES: set{},
-		UNUSED_TYPES: set{}
+		UNUSED_TYPES: set{},
+		IMPORTS: {},
+		METHODS: {},
+		STRUCTS: {},
+		IMPLEMENTED: {},
+		INTERFACES: {},
+		VARIABLES: {},
+		DIAGNOSTICS: []
 	})
 }
 
-// Add a package symbol to the table.
+// Add a package symbol to the table, remembering the path it was
+// imported from and the position of the import, if known.
 func PackageImported(st, pkg) {
+	PackageImported(st, pkg, nil, pkg)
+} (st, pkg, pos, path) {
 	dosync(st  alter  func{
 		$1 += {
 			pkg: PACKAGE,
-			UNUSED_PACKAGES: (*st)(UNUSED_PACKAGES)  conj  pkg
+			UNUSED_PACKAGES: (*st)(UNUSED_PACKAGES)  conj  pkg,
+			IMPORTS: (*st)(IMPORTS) += {pkg: {POSITION: pos, PATH: path}}
 		}
 	})
 }
@@ -47,11 +61,15 @@ func PackageCreated(st, pkg) {
 	}})
 }
 
-// Add a package symbol to the table.
+// Add a type symbol to the table, remembering the fully qualified
+// class name and the position of the import, if known.
 func TypeImported(st, typ) {
+	TypeImported(st, typ, nil, typ)
+} (st, typ, pos, className) {
 	dosync(st  alter  func{$1 += {
 		typ: TYPE,
-		UNUSED_TYPES: (*st)(UNUSED_TYPES)  conj  typ
+		UNUSED_TYPES: (*st)(UNUSED_TYPES)  conj  typ,
+		IMPORTS: (*st)(IMPORTS) += {typ: {POSITION: pos, PATH: className}}
 	}})
 	//dosync{
 	//	st := $1 += {
@@ -87,30 +105,166 @@ func HasType(st, typ) {
 	(*st)(typ) == TYPE
 }
 
+// Add a method declared with a receiver of type typ to the table,
+// with the numbers of parameters other than the receiver that it can
+// be called with.
+func MethodDeclared(st, typ, method, arities) {
+	dosync(st  alter  func{
+		updateIn($1, [METHODS, typ, method], func(old) { into(set(old), arities) })
+	})
+}
+
+// Return the names of the methods declared with a receiver of type typ.
+func Methods(st, typ) {
+	set(keys(get((*st)(METHODS), typ)))
+}
+
+// Has a method with this name been declared with a receiver of any
+// type?
+func HasMethod(st, method) {
+	boolean(some(func{$1  isContains  method}, vals((*st)(METHODS))))
+}
+
+// Return a map of the name of each method declared with a receiver to
+// the numbers of parameters it can be called with, whatever the type
+// of the receiver.
+func MethodArities(st) {
+	apply(mergeWith, into, {}, vals((*st)(METHODS)))
+}
+
+// Add a struct to the table, with its fields, including those
+// promoted from the structs it embeds, and the names of the structs it
+// embeds.
+func StructDeclared(st, typ, fields, embedded) {
+	dosync(st  alter  func{
+		assocIn($1, [STRUCTS, typ], {FIELDS: vec(fields), EMBEDDED: vec(embedded)})
+	})
+}
+
+// Return the names of the structs declared.
+func Structs(st) {
+	keys((*st)(STRUCTS))
+}
+
+// Return the fields of the struct, in order, or nil if it has not
+// been declared.
+func Fields(st, typ) {
+	getIn(*st, [STRUCTS, typ, FIELDS])
+}
+
+// Return the names of the structs that the struct embeds.
+func Embedded(st, typ) {
+	getIn(*st, [STRUCTS, typ, EMBEDDED])
+}
+
+// Add to the table a protocol that the type is extended to implement.
+func ProtocolImplemented(st, typ, protocol) {
+	dosync(st  alter  func{
+		updateIn($1, [IMPLEMENTED, typ], func(old) { set(old)  conj  protocol })
+	})
+}
+
+// Return the protocols that the type is extended to implement.
+func Implemented(st, typ) {
+	set(getIn(*st, [IMPLEMENTED, typ]))
+}
+
+// Add an interface to the table, with the signatures of its methods,
+// which map the name of each method to the set of the numbers of
+// parameters other than the receiver that it can be called with.
+func InterfaceDeclared(st, typ, signatures) {
+	dosync(st  alter  func{
+		assocIn($1, [INTERFACES, typ], signatures)
+	})
+}
+
+// Return the signatures of the methods of the interface, or nil if it
+// has not been declared.
+func Signatures(st, typ) {
+	getIn(*st, [INTERFACES, typ])
+}
+
+// Add to the table a type that a variable, parameter or receiver with
+// the given name is declared with.
+func VariableTyped(st, variable, typ) {
+	dosync(st  alter  func{
+		updateIn($1, [VARIABLES, variable], func(old) { set(old)  conj  typ })
+	})
+}
+
+// Return the types that the variables, parameters and receivers with
+// the given name have been declared with.
+func VariableTypes(st, variable) {
+	set(getIn(*st, [VARIABLES, variable]))
+}
+
+// Remove from the table the types of all the variables, parameters
+// and receivers, as they go out of scope.
+func VariablesDropped(st) {
+	dosync(alter(st, assoc, VARIABLES, {}))
+}
+
+// Return the fully qualified class name that the type was imported
+// from, or nil if it was not imported.
+func ClassName(st, typ) {
+	getIn(*st, [IMPORTS, typ, PATH])
+}
+
 // Return a string representation of packages in the table.
 func Packages(st) {
 	const packages = for [symbol, key] := lazy *st if key == PACKAGE { symbol }
-	str("[", ", "  string.join  packages, "]")
+	str("[", ", "  string.join  sort(packages), "]")
 }
 
 // Return a string representation of types in the table.
 func Types(st) {
 	const packages = for [symbol, key] := lazy *st if key == TYPE { symbol }
-	str("[", ", "  string.join  packages, "]")
+	str("[", ", "  string.join  sort(packages), "]")
+}
+
+// Add a diagnostic to those to be reported once the whole file has
+// been processed.
+func AddDiagnostic(st, diagnostic) {
+	dosync(st  alter  func{$1 += {
+		DIAGNOSTICS: (*st)(DIAGNOSTICS)  conj  diagnostic
+	}})
 }
 
-func CheckAllUsed(st) {
+// Return the diagnostics added to the table, in the order of their
+// positions in the file.
+func Diagnostics(st) {
+	juxt(LINE, COLUMN)  sortBy  (*st)(DIAGNOSTICS)
+}
+
+// Add an error diagnostic for each imported package or type that has
+// not been used in the file at path.
+func CheckAllUsed(st, path) {
 	const (
-		pkgs = (*st)(UNUSED_PACKAGES)
-		typs = (*st)(UNUSED_TYPES)
+		pkgs    = (*st)(UNUSED_PACKAGES)
+		typs    = (*st)(UNUSED_TYPES)
+		imports = (*st)(IMPORTS)
 	)
-	if notEmpty(pkgs) {
-		const pkgsS = ", "  string.join  pkgs
-		throw(new IOException(str("Packages imported but never used: [", pkgsS, "]")))
+	for pkg := range pkgs {
+		const (
+			{pos: POSITION, imported: PATH} = imports(pkg)
+			isAliased = last(imported  string.split  /\//) != pkg
+		)
+		AddDiagnostic(st, diagnostic.Error(
+			"E0103", path, pos,
+			if isAliased {
+				format(`"%s" imported as %s and not used`, imported, pkg)
+			} else {
+				format(`"%s" imported and not used`, imported)
+			},
+			`remove the import, or import it as _ if it is only needed for its side effects`
+		))
 	}
-	if notEmpty(typs) {
-		const typsS = ", "  string.join  typs
-		throw(new IOException(str("Types imported but never used: [", typsS, "]")))
+	for typ := range typs {
+		const {pos: POSITION, imported: PATH} = imports(typ)
+		AddDiagnostic(st, diagnostic.Error(
+			"E0104", path, pos,
+			format(`"%s" imported and not used`, imported),
+			`remove the type import`
+		))
 	}
-
 }
@@ -0,0 +1,204 @@
+//////
+// This file is part of the Funcgo compiler.
+//
+// Copyright (c) 2014 Eamonn O'Brien-Strain All rights
+// reserved. This program and the accompanying materials are made
+// available under the terms of the Eclipse Public License v1.0 which
+// accompanies this distribution, and is available at
+// http://www.eclipse.org/legal/epl-v10.html
+//
+// Contributors:
+// Eamonn O'Brien-Strain e@obrain.com - initial author
+//////
+
+// Translation of instaparse parse failures into diagnostics with
+// messages in the style of the Go compiler, and hints about the
+// mistakes that are commonly made in Funcgo.
+
+package syntaxerror
+import (
+	"clojure/string"
+	"funcgo/diagnostic"
+)
+import type java.util.regex.Matcher
+
+kToken := /[\p{L}_][\p{L}\p{N}_]*|\d+(?:\.\d+)?|:=|<-|<:|->|::|\.\.\.|&&|\|\||[=!<>]=|\S/
+kPreviousToken := /([\p{L}_][\p{L}\p{N}_]*|\d+(?:\.\d+)?|:=|<-|<:|->|::|\.\.\.|&&|\|\||[=!<>]=|\S)(\s*)$/
+kWord := /^[\p{L}\p{N}_]+$/
+
+// Matches the tokens that continue an expression but cannot start one.
+kContinuation := /^(?:\|\||&&|->|\.|,)$/
+
+// Matches the space before the start of a block comment that is never
+// terminated.
+kUnterminatedComment := /^(\s*)\/\*(?![\s\S]*\*\/)/
+
+// Return the token starting at index in text, "\n" if there is a
+// newline there, or nil at the end of the text.
+func tokenAt(text String, index) {
+	start := loop(i = index) {
+		if i < count(text) && (text[i] == ' ' || text[i] == '\t' || text[i] == '\r') {
+			recur(i + 1)
+		} else {
+			i
+		}
+	}
+	switch {
+	case start >= count(text):
+		nil
+	case text[start] == '\n':
+		"\n"
+	default: {
+		matcher Matcher := reMatcher(kToken, text)
+		matcher->region(start, count(text))
+		if matcher->lookingAt() { matcher->group() }
+	}
+	}
+}
+
+// Return the last token before index in text and the space after it.
+func tokenBefore(text String, index) {
+	window := subs(text, max(0, index - 1000), index)
+	if [_, token, space] := reFind(kPreviousToken, window); token {
+		[token, space]
+	} else {
+		[nil, ""]
+	}
+}
+
+func describe(token) {
+	switch {
+	case isNil(token):   "end of file"
+	case token == "\n": "newline"
+	default:             str("'", token, "'")
+	}
+}
+
+// Return the tokens the parser was expecting, as quoted strings.
+func expected(failure) {
+	strings := for r := lazy get(failure, REASON) if r(TAG) == STRING { str("'", r(EXPECTING), "'") }
+	sort(distinct(strings))
+}
+
+// Return the number of times that c occurs in text.
+func occurrences(text, c) {
+	count(func{ $1 == c }  filter  text)
+}
+
+// Return whether the end of text is inside the block of a go
+// statement, which is the only place the channel operator '<:' may be
+// used.
+func isInGoBlock(text String) {
+	matcher Matcher := reMatcher(/\bgo\s*\{/, text)
+	loop() {
+		if matcher->find() {
+			block := subs(text, matcher->start())
+			if occurrences(block, '{') > occurrences(block, '}') {
+				true
+			} else {
+				recur()
+			}
+		} else {
+			false
+		}
+	}
+}
+
+// Return hints about the likely cause of a failure at index in text,
+// where the unexpected token was found after the previous token.
+func hints(text String, index, unexpected, previous, space) {
+	before    := subs(text, max(0, index - 1000), index)
+	isInConst := reFind(/\bconst\s*\([^)]*$|\bconst\b[^\n]*$/, before)
+	isInDecl  := reFind(/\b(const|var)\b[^\n]*$/, before)
+	line      := reFind(/[^\n]*$/, before)
+	filter(identity, [
+		if previous == ":=" || previous == "=" {
+			str("missing expression after '", previous, "'",
+				if isInConst { " in const block" } else { "" })
+		},
+		if unexpected == ":=" {
+			`':=' is only allowed at the start of a block, or at the top of the file before the expressions that use it`
+		},
+		if unexpected == "=" && !isInDecl && reFind(kWord, str(previous)) {
+			`there is no assignment in Funcgo: use ':=' or const to give a value a name`
+		},
+		if space == " " && reFind(kWord, str(previous)) && reFind(kWord, str(unexpected)) {
+			format(`an infix function call needs two spaces either side of the function, as in: %s  %s  x`,
+				previous, unexpected)
+		},
+		if reFind(/\n/, space) && reFind(kContinuation, str(unexpected)) {
+			format(`the newline after '%s' ends the statement, so put '%s' at the end of that line instead`,
+				previous, unexpected)
+		},
+		if (unexpected == "<:" || reFind(/<:/, line)) && !isInGoBlock(before) {
+			`'<:' is only allowed inside a go block, so use '<-' here or put the code in go { ... }`
+		},
+		if isNil(unexpected) && occurrences(text, '{') > occurrences(text, '}') {
+			`missing closing '}'`
+		},
+		if isNil(unexpected) && occurrences(text, '(') > occurrences(text, ')') {
+			`missing closing ')'`
+		}
+	])
+}
+
+// Return the padding that puts a caret under the given one-based
+// column of line, keeping any tabs so that it lines up whatever the
+// tab width.
+func caretPad(line String, column) {
+	prefix := subs(line, 0, min(count(line), column - 1))
+	string.replace(prefix, /[^\t]/, " ")
+}
+
+// Return the one-based {LINE, COLUMN} position of index in text.
+func positionOf(text String, index) {
+	lines := string.split(subs(text, 0, index), /\n/, -1)
+	{LINE: count(lines), COLUMN: count(last(lines)) + 1}
+}
+
+// Return a diagnostic for the block comment starting at index in the
+// text of the Funcgo file path that is never terminated, or nil if
+// there is no such comment there.
+func unterminatedComment(path, text String, index) {
+	if [_, space] := reFind(kUnterminatedComment, subs(text, index)); space {
+		pos := positionOf(text, index + count(space))
+		diagnostic.Error("E0002", path, pos, "block comment not terminated", "end the comment with */")
+	}
+}
+
+// Return the syntax error diagnostic for the parse failure of the
+// text of the Funcgo file path.
+func syntaxDiagnostic(path, text String, failure) {
+	index             := get(failure, INDEX)
+	unexpected        := tokenAt(text, index)
+	[previous, space] := tokenBefore(text, index)
+	expecting         := expected(failure)
+	message           := str(
+		"syntax error: unexpected ", describe(unexpected),
+		if isEmpty(expecting) || count(expecting) > 4 {
+			""
+		} else {
+			", expecting "  str  (" or "  string.join  expecting)
+		}
+	)
+	diagnostic.Error(
+		"E0001", path, positionOf(text, index), message,
+		...hints(text, index, unexpected, previous, space)
+	)
+}
+
+// Return the diagnostic with an EXCERPT of the line of the source
+// where it is.
+func withExcerpt(source String, d) {
+	sourceLine := nth(string.splitLines(source), d(LINE) - 1, "")
+	d += {EXCERPT: str(sourceLine, "\n", caretPad(sourceLine, d(COLUMN)), "^")}
+}
+
+// Return a diagnostic for the parse failure of the Funcgo file path
+// whose contents are source.  The text that was parsed is the source
+// with some parts possibly blanked out.  A failure at a block comment
+// that is never terminated gets its own diagnostic.
+func Diagnostic(path, source String, text String, failure) {
+	index := get(failure, INDEX)
+	withExcerpt(source, unterminatedComment(path, text, index) || syntaxDiagnostic(path, text, failure))
+}
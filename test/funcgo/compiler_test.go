@@ -200,7 +200,7 @@ func parseNoPretty(expr) {
 }
 
 func parsedNoPretty(expr) {
-        str("(ns foo (:gen-class) ) (set! *warn-on-reflection* true) ", expr)
+        str("(ns foo (:gen-class)) (set! *warn-on-reflection* true) ", expr)
 }
 
 test.fact("can refer to symbols",
@@ -583,6 +583,30 @@ aaa9`)  ,=>, parsed("aaa9"),
 	parse(`///////
 aaa11`)                ,=>, parsed("aaa11")
 )
+test.fact("block comment",
+	parse("/* a block\ncomment */\naaa0")  ,=>, parsed("aaa0"),
+	parse("[a, /* the b */ b]")             ,=>, parsed("[a b]"),
+	parse("a /* over */ / b")               ,=>, parsed("(/ a b)"),
+	parse("aaa /* then\n */ bbb")           ,=>, parsed("aaa bbb"),
+	parse("f(a /* x\n y */, b)")            ,=>, parsed("(f a b)"),
+	parse("a /* x\n y */ || b")             ,=>, parsed("(or a b)"),
+	compileString("foo.go", "package foo\nimport(\n  b \"bar\" /* the bar */\n  /* and */\n)\nb.xxx"),
+	=>, `(ns foo (:gen-class) (:require [bar :as b])) (set! *warn-on-reflection* true) b/xxx`
+)
+test.fact("a newline ends a statement only after an identifier, a literal or a closing bracket",
+	parse("a +\n  b")                            ,=>, parsed("(+ a b)"),
+	parse("a ||\n  b &&\n  c")                   ,=>, parsed("(or a (and b c))"),
+	parse("foo->\n  bar()->\n  baz(a,\n    b)")  ,=>, parsed("(. (. foo (bar)) (baz a b))"),
+	parse("f(\n  a,\n  b\n)")                    ,=>, parsed("(f a b)"),
+	parse("[\n  /a/,\n  /b/\n]")                 ,=>, parsed(`[#"a" #"b"]`),
+	parse("a\n-b")                               ,=>, parsed("a (- b)"),
+	parse("a /\n  b")                            ,=>, parsed("(/ a b)"),
+	parse("if a {\n  b\n}\nelse {\n  c\n}")       ,=>, parsed("(if a b c)"),
+	parse("try {\n  a\n}\ncatch T e {\n  b\n}\nfinally {\n  c\n}", [], ["a.T"]),
+	=>, parsed("(try a (catch T e b) (finally c))", [], ["a T"]),
+	compileString("foo.go", "package foo\nimport(\n  \"bar\"\n  \"baz\"\n)\nbar.x(baz.y)\n"),
+	=>, `(ns foo (:gen-class) (:require [bar :as bar] [baz :as baz])) (set! *warn-on-reflection* true) (bar/x baz/y)`
+)
 test.fact("bug1",
 	parse(`{
 		// Words ending in 'ox' pluralize with 'en' (and not 'es')
@@ -892,7 +916,8 @@ func main() {
 
     Println(x, y, x+y)
 }`), =>, parsedAsync(str(
-	"(defn main [] (let",
+	";; receive from c",
+	" (defn main [] (let",
 	" [a [7 2 8 (- 9) 4 0]",
 	" c (chan)]",
 	" (go (sum (take (/ (count a) 2) a) c))",
@@ -943,10 +968,10 @@ test.fact("character literals",
 	parse("'\\t'") ,=>, parsed("\\tab"),
 	parse("'\\b'") ,=>, parsed("\\backspace"),
 	parse("'\\r'") ,=>, parsed("\\return"),
-	parseNoPretty("'\\uDEAD'") ,=>, parsedNoPretty("\\uDEAD"),
+	parseNoPretty("'\\uDEAD'") ,=>, parsedNoPretty("\\udead"),
 	parseNoPretty("'\\ubeef'") ,=>, parsedNoPretty("\\ubeef"),
 	parseNoPretty("'\\u1234'") ,=>, parsedNoPretty("\\u1234"),
-	parseNoPretty("'\\234'") ,=>, parsedNoPretty("\\o234")
+	parseNoPretty("'\\234'") ,=>, parsedNoPretty("\\u009c")
 )
 
 test.fact("indexing",
@@ -1029,7 +1054,7 @@ func FooBar(iii, jjj) {
   )
 }
 `)  ,=>, str(
-	`(ns foo (:gen-class) (:require [bar.baz :as b] [foo.faz.fedudle :as ff])) (set! *warn-on-reflection* true) (def ^:private x (b/bbb "blah blah")) (defn Foo-bar [iii jjj] (ff/fumanchu {:ooo (fn [m n] (str m n)) :ppp (fn [m n] (str m n)) :qqq qq }))`
+	`(ns foo (:gen-class) (:require [bar.baz :as b] [foo.faz.fedudle :as ff])) (set! *warn-on-reflection* true) (def ^:private x (b/bbb "blah blah")) (defn Foo-bar [iii jjj] (ff/fumanchu {:ooo (fn [m n] (str m n)) :ppp (fn [m n] (str m n)) :qqq qq}))`
 ))
 
 test.fact("full source file with async", fgo.Parse("foo.go", `
@@ -1054,7 +1079,7 @@ func FooBar(iii, jjj) {
 `)  ,=>, str(
 	`(ns foo (:gen-class) (:require [bar.baz :as b] [foo.faz.fedudle :as ff] `,
 	requireAsync,
-	`)) (set! *warn-on-reflection* true) (def ^:private x (b/bbb "blah blah")) (defn Foo-bar [iii jjj] (ff/fumanchu {:ooo (fn [m n] (str m n)) :ppp (fn [m n] (go (str m n))) :qqq qq }))`
+	`)) (set! *warn-on-reflection* true) (def ^:private x (b/bbb "blah blah")) (defn Foo-bar [iii jjj] (ff/fumanchu {:ooo (fn [m n] (str m n)) :ppp (fn [m n] (go (str m n))) :qqq qq}))`
 ))
 
 
@@ -1122,7 +1147,11 @@ type Interface interface {
         Swap(i, j int)
 }
 `), =>, parsed(str(
-	`(defprotocol Interface`,
+	`;; Len is the number of elements in the collection.`,
+	` ;; Less reports whether the element with`,
+	` ;; index i should sort before the element with index j.`,
+	` ;; Swap swaps the elements with indexes i and j.`,
+	` (defprotocol Interface`,
 	` (^long Len [this])`,
 	` (^boolean Less [this i ^int j])`,
 	` (Swap [this i ^int j]))`))
@@ -1134,19 +1163,40 @@ type Interface interface {
 //`), =>, parsed(`(defrecord Sequence [??]`)
 //)
 
-// test.fact("dispatch",
-//  	parse(`func (v Vertex) Manh() float64 { return v->X + v->X }`),
-//  	=>, parsed(str(
-// 		`(defprotocol __ManhProtocol (Manh [v]))`,
-// 		` (extend-type Vertex __ManhProtocol (Manh [v] (+ (. v X) (. v Y))))`
-// 	)),
-	
-//  	parse(`func (v Vertex) plusX(x) float64 { return x + v->X}`),
-//  	=>, parsed(str(
-// 		`(defprotocol __plusXProtocol (PlusX [v x]))`,
-// 		` (extend-type Vertex __plusXProtocol (PlusX [v x] (+ (. v X) x)))`
-// 	))
-// )
+test.fact("methods with receivers",
+	parse(`func (v Vertex) Manh() float64 { v->X + v->Y }`, [], ["a.Vertex"]),
+	=>, parsed(str(
+		`(defprotocol ^:private __ManhProtocol (Manh [this]))`,
+		` (extend-type Vertex __ManhProtocol (Manh ^double [v] (+ (. v X) (. v Y))))`
+	), [], ["a Vertex"]),
+
+	parse(`func (*Vertex) plusX() {this->X} (x) {this->X + x}
+Vertex{1, 2}->plusX(3)`, [], ["a.Vertex"]),
+	=>, parsed(str(
+		`(defprotocol ^:private __plusXProtocol (plusX [this] [this x1]))`,
+		` (extend-type Vertex __plusXProtocol (plusX ([this] (. this X)) ([this x] (+ (. this X) x))))`,
+		` (plusX (Vertex. 1 2) 3)`
+	), [], ["a Vertex"]),
+
+	parse(`func (r Row) Len() {2}
+func (r Row) Size() {[r->Len(), r->size()]}`, [], ["a.Row"]),
+	=>, parsed(str(
+		`(defprotocol ^:private __LenProtocol (Len [this]))`,
+		` (defprotocol ^:private __SizeProtocol (Size [this]))`,
+		` (extend-type Row __LenProtocol (Len [r] 2))`,
+		` (extend-type Row __SizeProtocol (Size [r] [(Len r) (. r (size))]))`
+	), [], ["a Row"]),
+
+	parse(`func (r Row) Len() {2}
+func g(r Row) {r->Len()}
+func f(r String) {r->Len()}`, [], ["a.Row"]),
+	=>, parsed(str(
+		`(defprotocol ^:private __LenProtocol (Len [this]))`,
+		` (extend-type Row __LenProtocol (Len [r] 2))`,
+		` (defn- g [^Row r] (Len r))`,
+		` (defn- f [^String r] (. r (Len)))`
+	), [], ["a Row"])
+)
 
 test.fact("implements",
 
@@ -1205,6 +1255,28 @@ test.fact("struct",
 		` Object (toString [this] (str "{" val " " l " " r "}")))`))
 )
 
+test.fact("embedded structs",
+	parse(`type Point struct{x; y}; type Circle struct{Point; r; y}`),
+	=>,
+	parsed(str(`(defrecord Point [x y]`,
+		` Object (toString [this] (str "{" x " " y "}")))`,
+		` (defrecord Circle [x r y]`,
+		` Object (toString [this] (str "{" x " " r " " y "}")))`)),
+
+	parse(`type User struct{name}
+type Admin struct{User; level}
+func (u User) Greeting() {"hi "  str  u->name}
+Admin{"al", 3}->Greeting()`),
+	=>,
+	parsed(str(`(defprotocol ^:private __GreetingProtocol (Greeting [this]))`,
+		` (defrecord User [name] Object (toString [this] (str "{" name "}")))`,
+		` (defrecord Admin [name level] Object (toString [this] (str "{" name " " level "}")))`,
+		` (extend-type User __GreetingProtocol (Greeting [u] (str "hi " (. u name))))`,
+		` (extend Admin __GreetingProtocol (into {} (for [[k f] (get-in __GreetingProtocol [:impls User])]`,
+		` [k (fn [this & args] (apply f (map->User this) args))])))`,
+		` (Greeting (Admin. "al" 3))`))
+)
+
 test.fact("switch",
 	parse(`switch {case a: b; case c: d; default: e}`),
 	=>, parsed(`(cond a b c d :else e)`),
@@ -1212,6 +1284,13 @@ test.fact("switch",
 	parse(`switch x.(type) {case String: x; case Integer: str(x*x); default: str(x)}`),
 	=>, parsed(`(cond (instance? String x) x (instance? Integer x) (str (* x x)) :else (str x))`),
 
+	parse(`switch v := f(x).(type) {case String: v->length(); case Long, Integer: v + 1; case nil: 0; default: v}`),
+	=>, parsed(str(`(let [v (f x)] (cond`,
+		` (instance? String v) (let [^String v v] (. v (length)))`,
+		` (or (instance? Long v) (instance? Integer v)) (+ v 1)`,
+		` (nil? v) 0`,
+		` :else v))`)),
+
 	parse(`switch x {case A: b; case C: d; default: e}`),
 	=>, parsed(`(case x :a b :c d e)`),
 
@@ -1236,25 +1315,34 @@ test.fact("Error if external package not imported",
 	parse("huh.bar"),
 	=>, test.throws(Exception, `package "huh" in huh.bar does not appear in imports []`),
 
-	parse("huh.bar", ["aaa", "bbb"], []),
-	=>, test.throws(Exception, `package "huh" in huh.bar does not appear in imports [bbb, aaa]`)
+	parse("aaa.foo(bbb.foo(huh.bar))", ["aaa", "bbb"], []),
+	=>, test.throws(Exception, `package "huh" in huh.bar does not appear in imports [aaa, bbb]`)
 )
 
 test.fact("Error if import not used",
 	parse("1234", "aaa"),
-	=>, test.throws(Exception, `Packages imported but never used: [aaa]`),
+	=>, test.throws(Exception, `"aaa" imported and not used`),
 
 	parse("1234", ["aaa", "bbb"], []),
-	=>, test.throws(Exception, /Packages imported but never used/),
+	=>, test.throws(Exception, str(`"aaa" imported and not used`, "\n", `"bbb" imported and not used`)),
 
 	parse("aaa.xxx", ["aaa", "bbb"], []),
-	=>, test.throws(Exception, `Packages imported but never used: [bbb]`),
+	=>, test.throws(Exception, `"bbb" imported and not used`),
 
 	parse("1234", [], ["a.Aaa", "b.Bbb"]),
-	=>, test.throws(Exception, /Types imported but never used/),
+	=>, test.throws(Exception, str(`"a.Aaa" imported and not used`, "\n", `"b.Bbb" imported and not used`)),
 
 	parse("Aaa::xxx", [], ["a.Aaa", "b.Bbb"]),
-	=>, test.throws(Exception, `Types imported but never used: [Bbb]`)
+	=>, test.throws(Exception, `"b.Bbb" imported and not used`)
+)
+
+test.fact("All errors in a file are reported together, in order",
+	parse("huh.bar(new Foo())", ["aaa"], []),
+	=>, test.throws(Exception, str(
+		`"aaa" imported and not used`, "\n",
+		`package "huh" in huh.bar does not appear in imports [aaa]`, "\n",
+		`type "Foo" does not appear in type imports [boolean, double, long]`
+	))
 )
 
 test.fact("import type",
@@ -1305,6 +1393,20 @@ test.fact("struct literal with trailing comma",
 	}`,[],["a.Vertex"]), =>, parsed(`(Vertex. 40.68433 (- 74.39967))`,[],["a Vertex"])
 )
 
+test.fact("keyed struct literal",
+	parse(`type Point struct{x; y}
+Point{y: 2}
+Point{}
+Point{1, 2}`),
+	=>, parsed(str(`(defrecord Point [x y] Object (toString [this] (str "{" x " " y "}")))`,
+		` (map->Point {:y 2}) (map->Point {}) (Point. 1 2)`)),
+
+	parse(`Vertex{
+		Lat: 40.68433,
+		Long: -74.39967,
+	}`,[],["a.Vertex"]), =>, parsed(`(Vertex/create {:Lat 40.68433 :Long (- 74.39967)})`,[],["a Vertex"])
+)
+
 test.fact("Calling function variadically",
 	parse(`foo(...args)`), =>, parsed(`(apply foo args)`),
 	parse(`foo(a, b, ...args)`), =>, parsed(`(apply foo a b args)`)
@@ -1326,3 +1428,54 @@ test.fact("Can have double-quotes in backtick string",
 //test.fact("",
 //      parse(``), =>, parsed(``),
 //)
+
+test.fact("generated forms carry their Funcgo source position",
+	fgo.Parse("foo.go", "package foo\nfunc f(x) {\n  g(x)\n}", SOURCEFILE, false, false, false, true),
+	=>, str(
+		`(ns foo (:gen-class)) (set! *warn-on-reflection* true)`,
+		` ^{:line 2, :column 1, :file "foo.go"} (defn- f [x] ^{:line 3, :column 3, :file "foo.go"} (g x))`
+	),
+
+	fgo.Parse("foo.go", "package foo\nfunc f(x) {\n  g(x)\n}"),
+	=>, `(ns foo (:gen-class)) (set! *warn-on-reflection* true) (defn- f [x] (g x))`
+)
+
+test.fact("compiled top-level forms start on the line of their Funcgo source",
+	nth(string.splitLines(fgoc.CompileString("foo.go", "package foo\n\n\n\n\n\n\n\n\nfunc f(x) {\n  g(x)\n}")), 9),
+	=>, "(defn- f [x] (g x))"
+)
+
+test.fact("comments are written before the nearest top-level declaration",
+	fgoc.CompileString("foo.go", "package foo\n\n// The answer\n\nvar X = f(1)  // once\n\n// Add one\nfunc G(x) {\n  x + 1\n}"),
+	=>, test.contains(";; The answer\n;; once\n(def X (f 1))\n"),
+
+	fgoc.CompileString("foo.go", "package foo\n\n// The answer\n\nvar X = f(1)  // once\n\n// Add one\nfunc G(x) {\n  x + 1\n}"),
+	=>, test.contains(`(defn G "Add one" [x] (+ x 1))`)
+)
+
+test.fact("doc comments become docstrings",
+	fgo.Parse("foo.go", "package foo\n// The answer\nvar X = 42", SOURCEFILE, false, false, false, true),
+	=>, test.contains(`(def X "The answer" 42)`),
+
+	fgo.Parse("foo.go", "package foo\n/* The answer */\nvar X = 42", SOURCEFILE, false, false, false, true),
+	=>, test.contains(`(def X "The answer" 42)`),
+
+	fgo.Parse("foo.go", "package foo\n// A shape\ntype Shape interface {\n  Area()\n}", SOURCEFILE, false, false, false, true),
+	=>, test.contains(`(defprotocol Shape "A shape" (Area [this]))`),
+
+	fgo.Parse("foo.go", "package foo\n// A point\ntype Point struct {x; y}", SOURCEFILE, false, false, false, true),
+	=>, test.contains(`(defrecord ^{:doc "A point"} Point [x y]`),
+
+	fgo.Parse("foo.go", "//////\n// Licence\n//////\n\n// Package foo\n// does things.\n\npackage foo\n42", SOURCEFILE, false, false, false, true),
+	=>, str(`(ns foo "Package foo`, "\n", `does things." (:gen-class)) (set! *warn-on-reflection* true) 42`)
+)
+
+test.fact("tabs are kept in string literals",
+	parse("`a\tb`"), =>, parsed(`"a\tb"`),
+
+	fgo.Parse("foo.go", "package foo\n\"a\tb\""), =>, test.contains("\"a\\tb\"")
+)
+
+test.fact("a tab can separate an infix function from its operands",
+	parse("a\tmap\tb"), =>, parsed("(map a b)")
+)
@@ -0,0 +1,41 @@
+package core_test
+import (
+        test "midje/sweet"
+        fgo "funcgo/core"
+)
+
+test.fact("Funcgo compiles to forms as read by the Clojure reader",
+	last(fgo.ParseForms("foo.go", "package foo\nfunc f(x) {\n  g(x)\n}")),
+	=>, list(symbol("defn-"), symbol("f"), [symbol("x")], list(symbol("g"), symbol("x"))),
+
+	meta(last(fgo.ParseForms("foo.go", "package foo\nfunc f(x) {\n  g(x)\n}"))),
+	=>, test.contains({LINE: 2, COLUMN: 1, FILE: "foo.go"}),
+
+	first(first(fgo.ParseForms("x.go", "func{$1 + 1}"))),
+	=>, symbol("fn*"),
+
+	fgo.ParseForms("x.go", "a + b", {START: EXPR}),
+	=>, [list(symbol("+"), symbol("a"), symbol("b"))]
+)
+
+test.fact("Funcgo code can be evaluated in a given namespace",
+	fgo.LoadString("x.go", "a := 20\na + 22", symbol("user")),
+	=>, 42,
+
+	fgo.LoadString("loadme.go", "package loadme\nfunc Answer() {\n  42\n}\nAnswer()", symbol("user")),
+	=>, 42,
+
+	fgo.LoadString("x.go", "inc(41)", symbol("funcgo.fresh-namespace")),
+	=>, 42,
+
+	fgo.LoadFile("funcgo/nosuchfile.go", symbol("user")),
+	=>, test.throws(Exception, "funcgo/nosuchfile.go is not on the classpath")
+)
+
+test.fact("a block of comments directly above a func is its docstring",
+	nth(last(fgo.ParseForms("foo.go", "package foo\n\n// Add one\n// to x.\nfunc Inc(x) {\n  x + 1\n}")), 2),
+	=>, "Add one\nto x.",
+
+	count(last(fgo.ParseForms("foo.go", "package foo\n// Add one\n\nfunc Inc(x) {\n  x + 1\n}"))),
+	=>, 4
+)
@@ -0,0 +1,159 @@
+package diagnostic_test
+import (
+        test "midje/sweet"
+        fgoc "funcgo/main"
+        "funcgo/diagnostic"
+        "funcgo/testfixture"
+)
+
+test.fact("unknown types are reported where they are used",
+	first(testfixture.Diagnostics("package foo\nfunc f() {\n  new HashMap()\n}")),
+	=>, test.contains({
+		SEVERITY: ERROR, CODE: "E0102", FILE: "foo.go",
+		LINE: 3, COLUMN: 7, END_LINE: 3, END_COLUMN: 14,
+		HINTS: ["import type java.util.HashMap"]
+	})
+)
+
+test.fact("a tab counts as one column",
+	first(testfixture.Diagnostics("package foo\nfunc f() {\n\tnew HashMap()\n}")),
+	=>, test.contains({LINE: 3, COLUMN: 6, END_COLUMN: 13})
+)
+
+test.fact("unused imports are reported where they are imported",
+	first(testfixture.Diagnostics("package foo\nimport (\n  \"aaa\"\n)\n1234")),
+	=>, test.contains({
+		CODE: "E0103", LINE: 3, COLUMN: 3,
+		MESSAGE: `"aaa" imported and not used`
+	})
+)
+
+test.fact("every error in a file is reported, each with its location",
+	for d := lazy testfixture.Diagnostics("package foo\nimport (\n  \"aaa\"\n  s \"clojure/string\"\n)\nhuh.bar") {
+		[d(CODE), d(LINE), d(COLUMN), d(MESSAGE)]
+	},
+	=>, [
+		["E0103", 3, 3, `"aaa" imported and not used`],
+		["E0103", 4, 3, `"clojure/string" imported as s and not used`],
+		["E0101", 6, 1, `package "huh" in huh.bar does not appear in imports [aaa, s]`]
+	]
+)
+
+test.fact("both sides of := must have the same number of values",
+	first(testfixture.Diagnostics("package foo\nfunc f() {\n  a, b := 1\n  a\n}")),
+	=>, test.contains({
+		CODE: "E0105", LINE: 3,
+		MESSAGE: "LHS and RHS of := do not match: a b := 1"
+	})
+)
+
+test.fact("c-style for loop identifiers must match",
+	first(testfixture.Diagnostics("package foo\nfor i := 0; j < 10; i++ {\n  i\n}")),
+	=>, test.contains({
+		CODE: "E0106", LINE: 2, COLUMN: 1,
+		HINTS: ["use i in all three clauses"]
+	})
+)
+
+test.fact("methods with receivers cannot be variadic",
+	first(testfixture.Diagnostics("package foo\nimport type a.Row\nfunc (r Row) f(xs...) {\n  xs\n}")),
+	=>, test.contains({
+		CODE: "E0108", LINE: 3, COLUMN: 1,
+		HINTS: ["pass the extra arguments as a single vector"]
+	})
+)
+
+test.fact("a method call on a receiver of unknown type must not be ambiguous",
+	first(testfixture.Diagnostics("package foo\nimport type a.Row\nfunc (r Row) Len() {\n  2\n}\nfunc f(x) {\n  x->Len()\n}")),
+	=>, test.contains({
+		CODE: "E0112", LINE: 7,
+		HINTS: ["declare the type of the receiver, as in x T, or call the method as a function: Len(x)"]
+	})
+)
+
+test.fact("the methods of embedded structs cannot be promoted in Goscript",
+	first(testfixture.Diagnostics("foo.gos", "package foo\ntype User struct{name}\nfunc (u User) Greeting() {\n  u->name\n}\ntype Admin struct{User; level}")),
+	=>, test.contains({
+		CODE: "E0113", LINE: 6,
+		MESSAGE: "the methods of User cannot be promoted to Admin in Goscript"
+	})
+)
+
+test.fact("struct literals must match the fields of the struct",
+	first(testfixture.Diagnostics("package foo\ntype Point struct{x; y}\nPoint{1, 2, 3}")),
+	=>, test.contains({
+		CODE: "E0109", LINE: 3, COLUMN: 1,
+		MESSAGE: "too many values in literal of struct Point, which has fields [x, y]"
+	}),
+
+	first(testfixture.Diagnostics("package foo\ntype Point struct{x; y}\nPoint{x: 1, z: 2}")),
+	=>, test.contains({
+		CODE: "E0110", LINE: 3, COLUMN: 1,
+		HINTS: ["the fields of Point are [x, y]"]
+	})
+)
+
+test.fact("implements must match the methods of the interface",
+	for d := lazy testfixture.Diagnostics("package foo\ntype Shape interface {\n  Area()\n  Scale(k)\n  Name()\n}\nimplements Shape\nfunc (Sq) (\n  Aera() {1}\n  Scale() {this}\n)") {
+		[d(CODE), d(LINE), d(MESSAGE)]
+	},
+	=>, [
+		["E0111", 7, "Sq does not implement Shape: missing method Name"],
+		["E0111", 7, "Sq does not implement Shape: Aera is not a method of Shape"],
+		["E0111", 7, "Sq does not implement Shape: method Scale has 0 parameters, but Shape declares it with 1"]
+	],
+
+	second(testfixture.Diagnostics("package foo\ntype Shape interface {\n  Area()\n}\nimplements Shape\nfunc (Sq) Aera() {1}\nimplements Shape\nfunc (Sq) Area() {1}")),
+	=>, nil,
+
+	for d := lazy testfixture.Diagnostics("package foo\ntype Shape interface {\n  Area()\n}\nimplements Shape\nfunc (Sq) (\n  Area() {1}\n  Area(k) {k}\n)") {
+		d(MESSAGE)
+	},
+	=>, ["Sq does not implement Shape: method Area has 1 parameters, but Shape declares it with 0"],
+
+	first(testfixture.Diagnostics("package foo\ntype Shape interface {\n  Area()\n}\nimplements Shape\nfunc (Sq) Aera() {1}")),
+	=>, test.contains({HINTS: ["did you mean Area?"]})
+)
+
+test.fact("implements of an interface of another package is checked if its class is loaded",
+	first(testfixture.Diagnostics("package foo\nimport type clojure.lang.Seqable\nimplements Seqable\nfunc (Sq) sqe() {nil}")),
+	=>, test.contains({
+		CODE: "E0111", LINE: 3,
+		MESSAGE: "Sq does not implement Seqable: sqe is not a method of Seqable",
+		HINTS: ["did you mean seq?"]
+	}),
+
+	testfixture.Diagnostics("package foo\nimport type clojure.lang.Seqable\nimplements Seqable\nfunc (Sq) seq() {nil}"),
+	=>, []
+)
+
+test.fact("syntax errors are reported where parsing failed",
+	first(testfixture.Diagnostics("package foo\nfunc f() {\n  )\n}")),
+	=>, test.contains({CODE: "E0001", LINE: 3})
+)
+
+test.fact("diagnostics are formatted like Go compiler errors",
+	diagnostic.Format(diagnostic.Error("E0105", "foo.go", {LINE: 2, COLUMN: 5}, "bad", "fix it")),
+	=>, "foo.go:2:5: error[E0105]: bad\n\thint: fix it",
+
+	diagnostic.Format(diagnostic.Error("E0000", "foo.go", nil, "oops")),
+	=>, "foo.go: error[E0000]: oops"
+)
+
+test.fact("diagnostics can be written as JSON",
+	diagnostic.Json(diagnostic.Error("E0001", "foo.go", {LINE: 1, COLUMN: 2, END_LINE: 1, END_COLUMN: 3}, "bad")),
+	=>, /"code":"E0001"/,
+
+	diagnostic.Json(diagnostic.Error("E0001", "foo.go", {LINE: 1, COLUMN: 2, END_LINE: 1, END_COLUMN: 3}, "bad")),
+	=>, /"end-column":3/,
+
+	diagnostic.Json(diagnostic.Error("E0001", "foo.go", nil, "bad")),
+	=>, /"line":null/
+)
+
+test.fact("the compiler can print only diagnostics, one JSON object per line",
+	testfixture.WithDir({"p.go": "package p\nimport \"aaa\"\n1234\n"}, func(dir) {
+		withOutStr(fgoc.Compile("--diagnostics=json", dir->getPath()))
+	}),
+	=>, /^\{.*"code":"E0103".*\}\n$/
+)
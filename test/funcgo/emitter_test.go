@@ -0,0 +1,38 @@
+package emitter_test
+import (
+        test "midje/sweet"
+        fgo "funcgo/core"
+        "funcgo/emitter"
+)
+import type java.io.StringWriter
+
+test.fact("the compiler generates Clojure data",
+	last(fgo.Forms("foo.go", "package foo\nfunc f(x) {\n  g(x)\n}")),
+	=>, list(symbol("defn-"), symbol("f"), [symbol("x")], list(symbol("g"), symbol("x"))),
+
+	meta(second(last(fgo.Forms("foo.go", "package foo\nvar x String = \"s\"")))),
+	=>, {PRIVATE: true, TAG: symbol("String")}
+)
+
+test.fact("forms are written with reader metadata and reader macros",
+	emitter.Emit([list(symbol("def"), withMeta(symbol("x"), {PRIVATE: true, TAG: symbol("long")}), 1)]),
+	=>, "(def ^:private ^long x 1)",
+
+	emitter.Emit([emitter.SyntaxQuote(list(symbol("f"), list(symbol("clojure.core", "unquote"), symbol("x"))))]),
+	=>, "`(f ~x)",
+
+	emitter.Emit([emitter.ShortFunction(list(symbol("+"), symbol("%1"), 1))]),
+	=>, "#(+ %1 1)",
+
+	emitter.Emit(["a\tb", '\n', 'é', /a"b/]),
+	=>, `"a\tb" \newline \u00e9 #"a\"b"`
+)
+
+test.fact("forms are pretty-printed with type hints and reader macros",
+	{
+		writer := new StringWriter()
+		emitter.Pprint([withMeta(symbol("x"), {TAG: symbol("String")}), emitter.ShortFunction(list(symbol("f"), symbol("%1")))], writer)
+		writer->toString()
+	},
+	=>, "[^String x #(f %1)]\n"
+)
@@ -0,0 +1,53 @@
+package fgodoc_test
+import (
+	test "midje/sweet"
+	fgo "funcgo/core"
+	"funcgo/fgodoc"
+)
+
+func documented(source) {
+	fgodoc.Package(fgo.Forms("shapes.go", source, SOURCEFILE, false, false, false, true))
+}
+
+var shapes = `// Package shapes measures shapes.
+package shapes
+
+// The number of sides of a square.
+var SquareSides = 4
+
+var hidden = 0
+
+// Return the area of a rectangle
+// of the given size.
+func RectArea(width, height) {
+  width * height
+}
+
+func helper(x) {
+  x
+}
+
+// A point in the plane.
+type Point struct {x; y}
+`
+
+test.fact("the documentation of a package lists the names it exports",
+	documented(shapes),
+	=>, {
+		NAMESPACE: "shapes",
+		DOC:       "Package shapes measures shapes.",
+		EXPORTS:   [
+			{NAME: "SquareSides", SIGNATURES: ["var SquareSides"], DOC: "The number of sides of a square."},
+			{NAME: "RectArea", SIGNATURES: ["func RectArea(width, height)"], DOC: "Return the area of a rectangle\nof the given size."},
+			{NAME: "Point", SIGNATURES: ["type Point struct {x, y}"], DOC: "A point in the plane."}
+		]
+	}
+)
+
+test.fact("package documentation is written as Markdown or HTML",
+	fgodoc.Markdown(documented("package shapes\n// Sum them.\nfunc Sum(xs...) {\n  count(xs)\n}")),
+	=>, "# package shapes\n\n## Sum\n\n```go\nfunc Sum(xs...)\n```\n\nSum them.\n\n",
+
+	fgodoc.Html(documented("package shapes\n// Is a < b?\nfunc Less(a, b) {\n  a < b\n}")),
+	=>, test.contains("<h2 id=\"Less\">Less</h2>\n<pre>func Less(a, b)</pre>\n<p>Is a &lt; b?</p>\n")
+)
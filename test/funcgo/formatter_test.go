@@ -0,0 +1,58 @@
+package formatter_test
+import (
+        test "midje/sweet"
+        fgoc "funcgo/main"
+        "funcgo/formatter"
+        "clojure/java/io"
+        "funcgo/testfixture"
+)
+
+test.fact("code is indented with tabs and infix calls have two spaces",
+	formatter.Format("x.go", "package x\nfunc f(x) {\n        if x {\n  a  str   b   \n}\n\n\n}\n"),
+	=>, "package x\nfunc f(x) {\n\tif x {\n\t\ta  str  b\n\t}\n\n}\n",
+
+	formatter.Format("x.go", "package x\nswitch x {\n    case A:\n  b\n    default:\n  c\n}\n"),
+	=>, "package x\nswitch x {\ncase A:\n\tb\ndefault:\n\tc\n}\n"
+)
+
+test.fact("comments and literals are kept as they are",
+	formatter.Format("x.go", "package x\n  // comment\nfoo(\"a  {\",\n     `raw\n  text`)\n"),
+	=>, "package x\n// comment\nfoo(\"a  {\",\n\t`raw\n  text`)\n",
+
+	formatter.Format("x.go", "package x\n/* keep {\n      this */\nfoo(1)\n"),
+	=>, "package x\n/* keep {\n      this */\nfoo(1)\n"
+)
+
+test.fact("changes in style can be shown as diffs",
+	formatter.Diff("x.go", "a\nb\n", "a\nc\n"),
+	=>, "--- x.go\n+++ x.go\n@@ -2,1 +2,1 @@\n-b\n+c\n",
+
+	formatter.Diff("x.go", "a\nc\n", "a\nb\nc\n"),
+	=>, "--- x.go\n+++ x.go\n@@ -1,0 +2,1 @@\n+b\n",
+
+	formatter.Diff("x.go", "a\nb\nc\n", "a\nc\n"),
+	=>, "--- x.go\n+++ x.go\n@@ -2,1 +1,0 @@\n-b\n",
+
+	formatter.Diff("x.go", "a\n", "a\n"),
+	=>, nil
+)
+
+test.fact("the fmt option lists, diffs or rewrites files whose style differs",
+	testfixture.WithDir({
+		"a.go": "package a\nfunc F() {\n  1\n}\n",
+		"b.go": "package b\nfunc F() {\n\t1\n}\n"
+	}, func(dir) {
+		[
+			withOutStr(fgoc.Compile("--fmt", "-l", dir->getPath())),
+			withOutStr(fgoc.Compile("--fmt", "-d", dir->getPath())),
+			withOutStr(fgoc.Compile("--fmt", dir->getPath())),
+			slurp(io.file(dir, "a.go"))
+		]
+	}),
+	=>, [
+		test.contains("a.go\n"),
+		test.contains("@@ -3,1 +3,1 @@\n-  1\n+\t1\n"),
+		"",
+		"package a\nfunc F() {\n\t1\n}\n"
+	]
+)
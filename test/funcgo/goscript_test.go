@@ -30,7 +30,7 @@ func parse(expr) {
 }
 
 func parsed(expr) {
-        str("(ns foo ) ", expr)
+        str("(ns foo) ", expr)
 }
 
 
@@ -0,0 +1,43 @@
+package loader_test
+import (
+        test "midje/sweet"
+        "funcgo/loader"
+)
+
+// Return the current clojure.core/load function.
+func currentLoad() {
+	varGet(findVar(symbol("clojure.core", "load")))
+}
+
+// Return the result of calling f with the loader installed.
+func withLoader(f) {
+	loader.Install()
+	try {
+		f()
+	} finally {
+		loader.Uninstall()
+	}
+}
+
+// Return whether installing the loader replaced clojure.core/load and
+// uninstalling it put back the original.
+func isRestored() {
+	before := currentLoad()
+	withLoader(func{ currentLoad() != before }) && currentLoad() == before
+}
+
+test.fact("require compiles Funcgo source found on the classpath",
+	withLoader(func() {
+		require(symbol("funcgo.loaderfixture"))
+		resolve(symbol("funcgo.loaderfixture", "Answer"))()
+	}),
+	=>, 42,
+
+	meta(resolve(symbol("funcgo.loaderfixture", "Answer")))(FILE),
+	=>, "funcgo/loaderfixture.go"
+)
+
+test.fact("uninstalling the loader puts back clojure.core/load",
+	isRestored(),
+	=>, true
+)
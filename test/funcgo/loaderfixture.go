@@ -0,0 +1,7 @@
+package loaderfixture
+
+// Loaded by the loader test from this source rather than from any
+// compiled .clj file.
+func Answer() {
+	42
+}
@@ -0,0 +1,133 @@
+package main_test
+import (
+        test "midje/sweet"
+        fgoc "funcgo/main"
+        "clojure/java/io"
+        "clojure/string"
+        "funcgo/testfixture"
+)
+
+test.fact("output can be written under separate Clojure and ClojureScript roots",
+	testfixture.WithDir({
+		"src/foo/bar.go":  "package bar\nfunc F(x) {\n  x\n}\n",
+		"src/foo/baz.gos": "package baz\nfunc G(x) {\n  x\n}\n"
+	}, func(dir) {
+		withOutStr(fgoc.Compile(
+			"--out-dir", io.file(dir, "out")->getPath(),
+			io.file(dir, "src")->getPath()
+		))
+		[
+			io.file(dir, "out/foo/bar.clj")->exists(),
+			io.file(dir, "src/foo/bar.clj")->exists(),
+			io.file(dir, "out/foo/baz.cljs")->exists(),
+			reFind(/"sources":\["[^"]*"\]/, slurp(io.file(dir, "out/foo/baz.cljs.map")))
+		]
+	}),
+	=>, [true, false, true, `"sources":["../../src/foo/baz.gos"]`]
+)
+
+test.fact("a single file is written under the output directory by its name",
+	testfixture.WithDir({"src/foo/bar.go": "package bar\nfunc F(x) {\n  x\n}\n"}, func(dir) {
+		withOutStr(fgoc.Compile(
+			"--out-dir", io.file(dir, "out")->getPath(),
+			io.file(dir, "src/foo/bar.go")->getPath()
+		))
+		for path := lazy ["out/bar.clj", "src/foo/bar.clj"] { io.file(dir, path)->exists() }
+	}),
+	=>, [true, false]
+)
+
+test.fact("hand-written Clojure files are never overwritten",
+	testfixture.WithDir({
+		"x.clj": "(ns x)\n",
+		"x.go":  "package x\nfunc F() {\n  1\n}\n"
+	}, func(dir) {
+		[
+			withOutStr(fgoc.Compile(dir->getPath())),
+			slurp(io.file(dir, "x.clj"))
+		]
+	}),
+	=>, [test.contains("error[E0201]"), "(ns x)\n"]
+)
+
+test.fact("cleaning deletes only the output of Funcgo files that no longer exist",
+	testfixture.WithDir({
+		"a.go":  "package a\nfunc F() {\n  1\n}\n",
+		"b.go":  "package b\nfunc F() {\n  1\n}\n",
+		"c.clj": "(ns c)\n"
+	}, func(dir) {
+		withOutStr(fgoc.Compile(dir->getPath()))
+		io.file(dir, "a.go")->delete()
+		withOutStr(fgoc.Compile("--clean", dir->getPath()))
+		for name := lazy ["a.clj", "b.clj", "c.clj"] { io.file(dir, name)->exists() }
+	}),
+	=>, [false, true, true]
+)
+
+test.fact("cleaning finds the Funcgo files wherever the tree has moved to",
+	testfixture.WithDir({
+		"src/a.go": "package a\nfunc F() {\n  1\n}\n",
+		"src/b.go": "package b\nfunc F() {\n  1\n}\n"
+	}, func(dir) {
+		withOutStr(fgoc.Compile(io.file(dir, "src")->getPath()))
+		io.file(dir, "src")->renameTo(io.file(dir, "moved"))
+		io.file(dir, "moved/a.go")->delete()
+		withOutStr(fgoc.Compile("--clean", io.file(dir, "moved")->getPath()))
+		[
+			for name := lazy ["a.clj", "b.clj"] { io.file(dir, "moved", name)->exists() },
+			first(string.splitLines(slurp(io.file(dir, "moved/b.clj"))))
+		]
+	}),
+	=>, [[false, true], ";; Compiled from b.go, relative to this file"]
+)
+
+test.fact("cleaning reads the headers written by the 0.5.1 compiler relative to the working directory",
+	testfixture.WithDir({
+		"a.go": "package a\nfunc F() {\n  1\n}\n"
+	}, func(dir) {
+		cwd := io.file(".")->getCanonicalFile()->toPath()
+		header := func(name) {
+			str(";; Compiled from ", cwd->relativize(io.file(dir, name)->getCanonicalFile()->toPath()), "\n(ns x)\n")
+		}
+		spit(io.file(dir, "a.clj"), header("a.go"))
+		spit(io.file(dir, "b.clj"), header("b.go"))
+		withOutStr(fgoc.Compile("--clean", dir->getPath()))
+		for name := lazy ["a.clj", "b.clj"] { io.file(dir, name)->exists() }
+	}),
+	=>, [true, false]
+)
+
+test.fact("watching a directory recompiles a Funcgo file in it when it is saved",
+	testfixture.WithDir({"a.go": "package a\nfunc F() {\n  1\n}\n"}, func(dir) {
+		watching := future(withOutStr(fgoc.Compile("--watch", dir->getPath())))
+		try {
+			loop(tries = 0) {
+				spit(io.file(dir, "a.go"), "package a\nfunc F() {\n  2\n}\n")
+				Thread::sleep(200)
+				if isRewritten := boolean(reFind(/ 2\)/, slurp(io.file(dir, "a.clj")))); isRewritten || tries > 50 {
+					isRewritten
+				} else {
+					recur(tries + 1)
+				}
+			}
+		} finally {
+			futureCancel(watching)
+		}
+	}),
+	=>, true
+)
+
+test.fact("watching takes only directories and cannot be combined with formatting or cleaning",
+	testfixture.WithDir({"a.go": "package a\nfunc F() {\n  1\n}\n"}, func(dir) {
+		[
+			boolean(reFind(/only watches directories/,
+				withOutStr(fgoc.Compile("--watch", io.file(dir, "a.go")->getPath())))),
+			boolean(reFind(/cannot be used with --fmt or --clean/,
+				withOutStr(fgoc.Compile("--watch", "--fmt", dir->getPath())))),
+			boolean(reFind(/cannot be used with --fmt or --clean/,
+				withOutStr(fgoc.Compile("--watch", "--clean", dir->getPath())))),
+			io.file(dir, "a.clj")->exists()
+		]
+	}),
+	=>, [true, true, true, false]
+)
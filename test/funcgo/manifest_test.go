@@ -0,0 +1,80 @@
+package manifest_test
+import (
+        test "midje/sweet"
+        fgo "funcgo/core"
+        fgoc "funcgo/main"
+        "funcgo/manifest"
+        "clojure/java/io"
+        "funcgo/testfixture"
+)
+
+test.fact("a manifest entry records the imports and the public interface of a file",
+	manifest.Entry("abc", fgo.Forms("foo/bar.go", "package bar\nimport \"foo/baz\"\nfunc F(x) {\n  baz.G(x)\n}\nfunc g() {\n  1\n}")),
+	=>, test.contains({
+		HASH: "abc",
+		NAMESPACE: "foo.bar",
+		IMPORTS: test.contains("foo.baz"),
+		EXPORTS: ["defn F [x]"]
+	}),
+
+	manifest.Dependents({"a.go": {IMPORTS: ["b", "c"]}, "d.go": {IMPORTS: ["e"]}}, ["c"]),
+	=>, ["a.go"]
+)
+
+// Return which of the files a.go and b.go the compiler output says
+// were compiled.
+func compiledFiles(out) {
+	[boolean(reFind(/a\.go/, out)), boolean(reFind(/b\.go/, out))]
+}
+
+// Compile the tree in dir after writing the given files to it, and
+// return which of the files a.go and b.go were compiled.
+func compileAfterWriting(dir, files) {
+	for [name, content] := range files {
+		spit(io.file(dir, name), content)
+	}
+	compiledFiles(withOutStr(fgoc.Compile(dir->getPath())))
+}
+
+test.fact("only files that changed or whose imports changed interface are recompiled",
+	testfixture.WithDir({}, func(dir) {
+		[
+			compileAfterWriting(dir, {
+				"a.go": "package a\nfunc F(x) {\n  x\n}\n",
+				"b.go": "package b\nimport \"a\"\nfunc G() {\n  a.F(1)\n}\n"
+			}),
+			compileAfterWriting(dir, {}),
+			compileAfterWriting(dir, {"a.go": "package a\nfunc F(x) {\n  x + 1\n}\n"}),
+			compileAfterWriting(dir, {"a.go": "package a\nfunc F(x, y) {\n  x + y\n}\n"})
+		]
+	}),
+	=>, [[true, true], [false, false], [true, false], [true, true]]
+)
+
+test.fact("compiling a tree a second time skips the files that have not changed",
+	testfixture.WithDir({}, func(dir) {
+		[
+			compileAfterWriting(dir, {
+				"a.go": "package a\nvar X = 1\nfunc F(x) {\n  g(x)\n}\nfunc g(x) {\n  x\n}\n",
+				"b.go": "package b\nfunc G() {\n  2\n}\n"
+			}),
+			compileAfterWriting(dir, {"b.go": "package b\nfunc G() {\n  3\n}\n"})
+		]
+	}),
+	=>, [[true, true], [false, true]]
+)
+
+test.fact("files can be compiled concurrently, with the output of each kept together",
+	testfixture.WithDir(
+		into({}, for name := lazy ["a", "b", "c", "d"] {
+			[name  str  ".go", str("package ", name, "\nfunc F(x) {\n  x\n}\n")]
+		}),
+		func(dir) {
+			count(reSeq(
+				/(?m)^ +(\w)\.go \.\.\.\n\t\t--> .*\1\.clj/,
+				withOutStr(fgoc.Compile("--jobs", "4", dir->getPath()))
+			))
+		}
+	),
+	=>, 4
+)
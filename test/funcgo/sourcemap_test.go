@@ -0,0 +1,50 @@
+package sourcemap_test
+import (
+        test "midje/sweet"
+        fgoc "funcgo/main"
+        "funcgo/sourcemap"
+        "clojure/java/io"
+        "funcgo/testfixture"
+)
+
+test.fact("source map positions are Base64 VLQ encoded",
+	sourcemap.Mappings([{GEN_LINE: 1, GEN_COLUMN: 1, SRC_LINE: 1, SRC_COLUMN: 1}]),
+	=>, "AAAA",
+
+	sourcemap.Mappings([
+		{GEN_LINE: 1, GEN_COLUMN: 1,  SRC_LINE: 1,  SRC_COLUMN: 1},
+		{GEN_LINE: 2, GEN_COLUMN: 1,  SRC_LINE: 3,  SRC_COLUMN: 1},
+		{GEN_LINE: 4, GEN_COLUMN: 17, SRC_LINE: 2,  SRC_COLUMN: 5}
+	]),
+	=>, "AAAA;AAEA;;gBADI"
+)
+
+test.fact("source map positions can be decoded",
+	sourcemap.Decode(";;;AAGA;;;;AAIA"),
+	=>, [
+		{GEN_LINE: 4, GEN_COLUMN: 1, SRC_LINE: 4, SRC_COLUMN: 1},
+		{GEN_LINE: 8, GEN_COLUMN: 1, SRC_LINE: 8, SRC_COLUMN: 1}
+	]
+)
+
+test.fact("compiling a .gos file writes a source map of its top-level forms",
+	testfixture.WithDir({"p.gos": `package p
+
+// a comment
+func f() {
+	1
+}
+
+f()
+`}, func(dir) {
+		fgoc.Compile(dir->getPath())
+		{
+			[_, mappings] := reFind(/"mappings":"([^"]*)"/, slurp(io.file(dir, "p.cljs.map")))
+			sourcemap.Decode(mappings)
+		}
+	}),
+	=>, [
+		{GEN_LINE: 4, GEN_COLUMN: 1, SRC_LINE: 4, SRC_COLUMN: 1},
+		{GEN_LINE: 8, GEN_COLUMN: 1, SRC_LINE: 8, SRC_COLUMN: 1}
+	]
+)
@@ -0,0 +1,93 @@
+package syntaxerror_test
+import (
+        test "midje/sweet"
+        "funcgo/testfixture"
+)
+
+// Return the first diagnostic from compiling the Funcgo code in foo.go.
+func syntaxError(fgoText) {
+	first(testfixture.Diagnostics(fgoText))
+}
+
+test.fact("parse failures say what was unexpected",
+	syntaxError("package foo\nfunc f() {\n  g(x))\n}"),
+	=>, test.contains({
+		CODE: "E0001", LINE: 3, COLUMN: 7,
+		MESSAGE: /^syntax error: unexpected '\)'/
+	}),
+
+	syntaxError("package foo\nfunc f() {\n  g(x)\n"),
+	=>, test.contains({
+		MESSAGE: /^syntax error: unexpected end of file/,
+		HINTS: ["missing closing '}'"]
+	})
+)
+
+test.fact("parse failures have hints about common Funcgo mistakes",
+	syntaxError("package foo\nconst (\n  a = \n)\na")(HINTS),
+	=>, test.contains(["missing expression after '=' in const block"]),
+
+	syntaxError("package foo\nfunc f() {\n  g(x)\n  y := 2\n  y\n}")(HINTS),
+	=>, test.contains([
+		`':=' is only allowed at the start of a block, or at the top of the file before the expressions that use it`
+	]),
+
+	syntaxError("package foo\nfunc f(x) {\n  x map g\n}")(HINTS),
+	=>, test.contains([
+		`an infix function call needs two spaces either side of the function, as in: x  map  x`
+	]),
+
+	syntaxError("package foo\nfunc f(a, b) {\n  select {\n  case <-a: 1\n  case <:b: 2\n  }\n}")(HINTS),
+	=>, test.contains([
+		`'<:' is only allowed inside a go block, so use '<-' here or put the code in go { ... }`
+	]),
+
+	syntaxError("package foo\nfunc f(a, b) {\n  a\n  || b\n}"),
+	=>, test.contains({
+		LINE: 4, COLUMN: 3,
+		MESSAGE: /^syntax error: unexpected '\|\|'/,
+		HINTS: [`the newline after 'a' ends the statement, so put '||' at the end of that line instead`]
+	})
+)
+
+test.fact("a block comment that is never terminated is reported as such",
+	testfixture.Diagnostics("package foo\nx := 1 /* oops\ny := 2\nx + y\n"),
+	=>, [test.contains({
+		CODE: "E0002", LINE: 2, COLUMN: 8,
+		MESSAGE: "block comment not terminated",
+		HINTS: ["end the comment with */"]
+	})]
+)
+
+test.fact("parse failures show the original line with a caret under the problem",
+	syntaxError("package foo\nfunc f() {\n\tg(x y)\n}"),
+	=>, test.contains({LINE: 3, COLUMN: 6, EXCERPT: "\tg(x y)\n\t    ^"})
+)
+
+test.fact("parsing recovers at the next top-level declaration to find more errors",
+	for d := lazy testfixture.Diagnostics(`package foo
+import "clojure/string"
+
+func f(x) {
+  g(x))
+}
+
+func h() {
+  string.join(huh.bar)
+}
+
+func k() {
+  1 +
+}
+`) {
+		[d(CODE), d(LINE)]
+	},
+	=>, [["E0001", 5], ["E0101", 9], ["E0001", 14]]
+)
+
+test.fact("lines inside raw strings and block comments do not start top-level declarations",
+	for d := lazy testfixture.Diagnostics("package foo\nfunc f() {\n  x := `\nraw`\n  g(x))\n}\nfunc h() {\n  /* a\nb */\n  1 +\n}\n") {
+		[d(CODE), d(LINE)]
+	},
+	=>, [["E0001", 5], ["E0001", 11]]
+)
@@ -0,0 +1,42 @@
+// Helpers shared by the tests: the diagnostics of compiling a snippet
+// of code, and temporary source trees to run the compiler on.
+
+package testfixture
+import (
+        fgo "funcgo/core"
+        "funcgo/diagnostic"
+        "clojure/java/io"
+)
+
+// Return the diagnostics from compiling the Funcgo code in foo.go, or
+// in the file at the given path.
+func Diagnostics(fgoText) {
+	Diagnostics("foo.go", fgoText)
+} (path, fgoText) {
+	try {
+		fgo.Parse(path, fgoText)
+		[]
+	} catch Exception e {
+		diagnostic.Of(path, e)
+	}
+}
+
+// Return the result of calling f with a new temporary directory that
+// holds the files, a map of paths relative to the directory to their
+// content.  The directory is deleted afterwards.
+func WithDir(files, f) {
+	dir := io.file(System::getProperty("java.io.tmpdir"), str("fgo", System::nanoTime()))
+	dir->mkdirs()
+	try {
+		for [path, content] := range files {
+			file := io.file(dir, path)
+			io.makeParents(file)
+			spit(file, content)
+		}
+		f(dir)
+	} finally {
+		for file := range reverse(fileSeq(dir)) {
+			io.deleteFile(file, true)
+		}
+	}
+}